@@ -1,13 +1,29 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"redditclone/internal/config"
 	"redditclone/internal/server"
 )
 
 func main() {
-	server := server.NewService()
-	err := server.Run()
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, err := server.NewService(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = server.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,90 @@
+// Package authz decides who may do what. Every user has a site-wide role,
+// moderators are appointed per community. Authors always control their
+// own posts and comments.
+package authz
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// Valid reports whether r is a known site-wide role.
+func (r Role) Valid() bool {
+	return r == RoleUser || r == RoleAdmin
+}
+
+// Subject is the user performing an action.
+type Subject struct {
+	ID   string
+	Name string
+	Role Role
+	// Moderates lists the communities the subject moderates.
+	Moderates []string
+}
+
+type Action string
+
+const (
+	EditPost      Action = "edit_post"
+	DeletePost    Action = "delete_post"
+	EditComment   Action = "edit_comment"
+	DeleteComment Action = "delete_comment"
+	// ViewRevisions shows the edit history of a post or a comment.
+	ViewRevisions Action = "view_revisions"
+	// Moderate reviews the moderation queue and decides on reported items.
+	Moderate Action = "moderate"
+	// ManageModerators appoints and removes moderators of a community.
+	ManageModerators Action = "manage_moderators"
+	// ManageUsers changes the roles of users.
+	ManageUsers  Action = "manage_users"
+	ViewAuditLog Action = "view_audit_log"
+)
+
+// Resource is what an action is performed on.
+type Resource struct {
+	// AuthorID is the author of a post or a comment, or the creator of
+	// a community.
+	AuthorID  string
+	Community string
+}
+
+// Can reports whether the subject may perform the action on the resource.
+func Can(s Subject, action Action, r Resource) bool {
+	isAuthor := s.ID != "" && s.ID == r.AuthorID
+	isAdmin := s.Role == RoleAdmin
+
+	switch action {
+	case EditPost, EditComment:
+		// nobody puts words in the mouth of an author
+		return isAuthor
+	case DeletePost, DeleteComment, ViewRevisions:
+		return isAuthor || isAdmin || s.moderates(r.Community)
+	case Moderate:
+		// authors do not judge their own posts
+		return isAdmin || s.moderates(r.Community)
+	case ManageModerators:
+		return isAuthor || isAdmin
+	case ManageUsers, ViewAuditLog:
+		return isAdmin
+	default:
+		return false
+	}
+}
+
+// Privileged reports whether the subject may perform the action only
+// because of its role. Privileged actions are recorded in the audit log.
+func Privileged(s Subject, action Action, r Resource) bool {
+	return Can(s, action, r) && !Can(Subject{ID: s.ID, Role: RoleUser}, action, r)
+}
+
+func (s Subject) moderates(community string) bool {
+	for _, c := range s.Moderates {
+		if c != "" && c == community {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package authz
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	author := Subject{ID: "author", Role: RoleUser}
+	stranger := Subject{ID: "stranger", Role: RoleUser}
+	moderator := Subject{ID: "moderator", Role: RoleUser, Moderates: []string{"news"}}
+	otherModerator := Subject{ID: "other", Role: RoleUser, Moderates: []string{"music"}}
+	admin := Subject{ID: "admin", Role: RoleAdmin}
+	anonymous := Subject{}
+
+	post := Resource{AuthorID: "author", Community: "news"}
+	orphan := Resource{Community: "news"}
+
+	tests := []struct {
+		name       string
+		subject    Subject
+		action     Action
+		resource   Resource
+		can        bool
+		privileged bool
+	}{
+		{"author edits", author, EditPost, post, true, false},
+		{"moderator edits", moderator, EditComment, post, false, false},
+		{"admin edits", admin, EditPost, post, false, false},
+		{"author deletes", author, DeletePost, post, true, false},
+		{"stranger deletes", stranger, DeletePost, post, false, false},
+		{"moderator deletes", moderator, DeleteComment, post, true, true},
+		{"moderator of another community deletes", otherModerator, DeletePost, post, false, false},
+		{"admin deletes", admin, DeletePost, post, true, true},
+		{"moderator views revisions", moderator, ViewRevisions, post, true, true},
+		{"author moderates", author, Moderate, post, false, false},
+		{"moderator moderates", moderator, Moderate, post, true, true},
+		{"moderator of another community moderates", otherModerator, Moderate, post, false, false},
+		{"admin moderates", admin, Moderate, post, true, true},
+		{"creator manages moderators", author, ManageModerators, post, true, false},
+		{"moderator manages moderators", moderator, ManageModerators, post, false, false},
+		{"admin manages moderators", admin, ManageModerators, post, true, true},
+		{"admin manages users", admin, ManageUsers, Resource{}, true, true},
+		{"user manages users", author, ManageUsers, Resource{}, false, false},
+		{"admin views audit log", admin, ViewAuditLog, Resource{}, true, true},
+		{"anonymous deletes post without author", anonymous, DeletePost, orphan, false, false},
+		{"unknown action", admin, Action("launch"), post, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Can(tt.subject, tt.action, tt.resource); got != tt.can {
+				t.Errorf("Can = %v, want %v", got, tt.can)
+			}
+			if got := Privileged(tt.subject, tt.action, tt.resource); got != tt.privileged {
+				t.Errorf("Privileged = %v, want %v", got, tt.privileged)
+			}
+		})
+	}
+}
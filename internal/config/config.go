@@ -0,0 +1,651 @@
+// Package config loads the server configuration. Every setting has a
+// default and can be overridden, in order of increasing precedence, by a
+// YAML, TOML or JSON config file, a REDDITCLONE_* environment variable and a
+// command-line flag.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "REDDITCLONE_"
+
+const (
+	MemoryStorage = "memory"
+	FileStorage   = "file"
+)
+
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// Trace exporters.
+const (
+	TraceNone   = "none"
+	TraceStdout = "stdout"
+	TraceFile   = "file"
+	TraceOTLP   = "otlp"
+)
+
+type Config struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout"`
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after a shutdown signal.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// MaxBodyBytes limits the size of API request bodies.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+	// ProblemDetails writes all API errors as RFC 7807 problem documents.
+	ProblemDetails bool `yaml:"problemDetails"`
+
+	// Admins are the names of users that get the admin role on startup.
+	Admins []string `yaml:"admins"`
+
+	Storage StorageConfig `yaml:"storage"`
+	JWT     JWTConfig     `yaml:"jwt"`
+	Assets  AssetsConfig  `yaml:"assets"`
+	Views   ViewsConfig   `yaml:"views"`
+
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	Lockout   LockoutConfig   `yaml:"lockout"`
+
+	Log     LogConfig     `yaml:"log"`
+	Metrics MetricsConfig `yaml:"metrics"`
+	Tracing TracingConfig `yaml:"tracing"`
+}
+
+type StorageConfig struct {
+	// Backend is MemoryStorage or FileStorage.
+	Backend string `yaml:"backend"`
+	// Path is the data file of FileStorage.
+	Path string `yaml:"path"`
+}
+
+type JWTConfig struct {
+	// Secret is an HS256 key with the id DefaultJWTKey. When neither Secret
+	// nor Keys are set a random secret is generated, so tokens do not
+	// survive a restart.
+	Secret string `yaml:"secret"`
+	// TTL is the lifetime of access tokens. RefreshTTL is how long a
+	// session lasts without being refreshed.
+	TTL        time.Duration `yaml:"ttl"`
+	RefreshTTL time.Duration `yaml:"refreshTTL"`
+	// Keys are all keys tokens are accepted from. Keeping the previous
+	// key here after switching SigningKey rotates keys without logging
+	// users out.
+	Keys []JWTKey `yaml:"keys"`
+	// SigningKey is the id of the key new tokens are signed with.
+	// It defaults to DefaultJWTKey.
+	SigningKey string `yaml:"signingKey"`
+}
+
+// DefaultJWTKey is the id of the key made from JWTConfig.Secret.
+const DefaultJWTKey = "default"
+
+// JWTAlgorithms are the supported signing algorithms.
+var JWTAlgorithms = []string{"HS256", "RS256", "EdDSA"}
+
+type JWTKey struct {
+	// ID is sent in the kid header of tokens signed with the key.
+	ID        string `yaml:"id"`
+	Algorithm string `yaml:"algorithm"`
+	// Secret is the inline secret of an HS256 key.
+	Secret string `yaml:"secret"`
+	// File holds the secret of an HS256 key or a PEM encoded RS256 or
+	// EdDSA key. A public key only verifies tokens.
+	File string `yaml:"file"`
+}
+
+type AssetsConfig struct {
+	// HTMLDir is served at the root, StaticDir under /static/.
+	HTMLDir   string `yaml:"htmlDir"`
+	StaticDir string `yaml:"staticDir"`
+}
+
+type ViewsConfig struct {
+	// Window is how long repeated views of the same viewer are ignored.
+	Window        time.Duration `yaml:"window"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// RateLimitConfig holds the request budgets of every IP address and every
+// user. Login and registration are only limited per IP.
+type RateLimitConfig struct {
+	Enabled  bool      `yaml:"enabled"`
+	Login    RateLimit `yaml:"login"`
+	Register RateLimit `yaml:"register"`
+	Post     RateLimit `yaml:"post"`
+	Comment  RateLimit `yaml:"comment"`
+	Vote     RateLimit `yaml:"vote"`
+}
+
+// RateLimit allows Requests per Period, with bursts of up to Burst
+// requests. Burst defaults to Requests.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+// String formats the limit as REQUESTS/PERIOD, the form of its flag.
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// Set parses REQUESTS/PERIOD, e.g. 10/1m. Burst is left as it is.
+func (l *RateLimit) Set(value string) error {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return errors.New("want REQUESTS/PERIOD, e.g. 10/1m")
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil {
+		return fmt.Errorf("requests: %w", err)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil {
+		return fmt.Errorf("period: %w", err)
+	}
+
+	l.Requests, l.Period = n, d
+	return nil
+}
+
+type LogConfig struct {
+	// Format is LogText or LogJSON.
+	Format string `yaml:"format"`
+	// Level is the minimum level of written entries: debug, info, warn
+	// or error.
+	Level slog.Level `yaml:"level"`
+}
+
+type MetricsConfig struct {
+	// Enabled serves the metrics at /metrics.
+	Enabled bool `yaml:"enabled"`
+	// Addr is the address of a separate admin listener for /metrics. When
+	// empty the metrics are served on the API address.
+	Addr string `yaml:"addr"`
+}
+
+type TracingConfig struct {
+	// Exporter is TraceNone, which turns tracing off, TraceStdout,
+	// TraceFile or TraceOTLP.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector, e.g.
+	// http://localhost:4318. When empty the OTEL_EXPORTER_OTLP_*
+	// environment variables apply.
+	Endpoint string `yaml:"endpoint"`
+	// File receives the spans of TraceFile as JSON, one span per line.
+	File string `yaml:"file"`
+	// SampleRatio is the share of new traces that are recorded. Traces
+	// continued from a caller follow its decision.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// LockoutConfig throttles failed logins per account and per IP address.
+type LockoutConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Account LockoutPolicy `yaml:"account"`
+	IP      LockoutPolicy `yaml:"ip"`
+}
+
+// LockoutPolicy allows FreeAttempts failed logins, then doubles the wait
+// before the next attempt starting at Delay. LockoutAttempts failures
+// lock logins for LockoutDuration.
+type LockoutPolicy struct {
+	FreeAttempts    int           `yaml:"freeAttempts"`
+	Delay           time.Duration `yaml:"delay"`
+	LockoutAttempts int           `yaml:"lockoutAttempts"`
+	LockoutDuration time.Duration `yaml:"lockoutDuration"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:            ":8081",
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		MaxBodyBytes:    1 << 20,
+		Storage: StorageConfig{
+			Backend: MemoryStorage,
+			Path:    "redditclone.db",
+		},
+		JWT: JWTConfig{
+			TTL:        15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+		},
+		Assets: AssetsConfig{
+			HTMLDir:   "./web/html",
+			StaticDir: "./web",
+		},
+		Views: ViewsConfig{
+			Window:        time.Hour,
+			FlushInterval: 10 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:  true,
+			Login:    RateLimit{Requests: 10, Period: time.Minute},
+			Register: RateLimit{Requests: 5, Period: time.Hour},
+			Post:     RateLimit{Requests: 5, Period: time.Minute},
+			Comment:  RateLimit{Requests: 20, Period: time.Minute},
+			Vote:     RateLimit{Requests: 60, Period: time.Minute},
+		},
+		Lockout: LockoutConfig{
+			Enabled: true,
+			Account: LockoutPolicy{FreeAttempts: 3, Delay: time.Second, LockoutAttempts: 10, LockoutDuration: 15 * time.Minute},
+			IP:      LockoutPolicy{FreeAttempts: 10, Delay: time.Second, LockoutAttempts: 50, LockoutDuration: 15 * time.Minute},
+		},
+		Log: LogConfig{
+			Format: LogText,
+			Level:  slog.LevelInfo,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    TraceNone,
+			File:        "traces.jsonl",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// bind registers a flag for every setting of c. The config file path goes
+// to configPath.
+func (c *Config) bind(fs *flag.FlagSet, configPath *string) {
+	fs.StringVar(configPath, "config", "", "path to a YAML, TOML or JSON config file")
+
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "maximum duration for reading a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "maximum duration for writing a response")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "how long idle keep-alive connections are kept")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long in-flight requests may take to finish on shutdown")
+	fs.Int64Var(&c.MaxBodyBytes, "max-body-bytes", c.MaxBodyBytes, "maximum size of API request bodies in bytes")
+	fs.BoolVar(&c.ProblemDetails, "problem-details", c.ProblemDetails, "write API errors as application/problem+json")
+
+	fs.Func("admins", "comma-separated names of users that get the admin role on startup", func(value string) error {
+		c.Admins = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Admins = append(c.Admins, name)
+			}
+		}
+		return nil
+	})
+
+	fs.StringVar(&c.Storage.Backend, "storage-backend", c.Storage.Backend, "storage backend: memory or file")
+	fs.StringVar(&c.Storage.Path, "storage-path", c.Storage.Path, "data file of the file storage backend")
+
+	fs.StringVar(&c.JWT.Secret, "jwt-secret", c.JWT.Secret, "secret that signs access tokens, random if empty")
+	fs.DurationVar(&c.JWT.TTL, "jwt-ttl", c.JWT.TTL, "lifetime of access tokens")
+	fs.DurationVar(&c.JWT.RefreshTTL, "jwt-refresh-ttl", c.JWT.RefreshTTL, "how long a session lasts without being refreshed")
+	fs.StringVar(&c.JWT.SigningKey, "jwt-signing-key", c.JWT.SigningKey, "id of the key that signs access tokens")
+
+	fs.StringVar(&c.Assets.HTMLDir, "assets-html-dir", c.Assets.HTMLDir, "directory with the frontend HTML")
+	fs.StringVar(&c.Assets.StaticDir, "assets-static-dir", c.Assets.StaticDir, "directory served under /static/")
+
+	fs.DurationVar(&c.Views.Window, "views-window", c.Views.Window, "how long repeated views of a post by the same viewer are ignored")
+	fs.DurationVar(&c.Views.FlushInterval, "views-flush-interval", c.Views.FlushInterval, "how often view counts are saved")
+
+	fs.BoolVar(&c.RateLimit.Enabled, "rate-limit", c.RateLimit.Enabled, "limit request rates per IP address and user")
+	fs.Var(&c.RateLimit.Login, "rate-limit-login", "login attempts per IP address, as REQUESTS/PERIOD")
+	fs.Var(&c.RateLimit.Register, "rate-limit-register", "registrations per IP address, as REQUESTS/PERIOD")
+	fs.Var(&c.RateLimit.Post, "rate-limit-post", "new posts per IP address and user, as REQUESTS/PERIOD")
+	fs.Var(&c.RateLimit.Comment, "rate-limit-comment", "comments per IP address and user, as REQUESTS/PERIOD")
+	fs.Var(&c.RateLimit.Vote, "rate-limit-vote", "votes per IP address and user, as REQUESTS/PERIOD")
+
+	fs.BoolVar(&c.Lockout.Enabled, "lockout", c.Lockout.Enabled, "delay and lock out repeated failed logins")
+	fs.IntVar(&c.Lockout.Account.LockoutAttempts, "lockout-attempts", c.Lockout.Account.LockoutAttempts, "failed logins that lock an account")
+	fs.DurationVar(&c.Lockout.Account.LockoutDuration, "lockout-duration", c.Lockout.Account.LockoutDuration, "how long an account is locked out")
+
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log format: text or json")
+	fs.TextVar(&c.Log.Level, "log-level", c.Log.Level, "minimum log level: debug, info, warn or error")
+
+	fs.BoolVar(&c.Metrics.Enabled, "metrics", c.Metrics.Enabled, "serve Prometheus metrics at /metrics")
+	fs.StringVar(&c.Metrics.Addr, "metrics-addr", c.Metrics.Addr, "separate address to serve metrics on, the API address if empty")
+
+	fs.StringVar(&c.Tracing.Exporter, "tracing-exporter", c.Tracing.Exporter, "trace exporter: none, stdout, file or otlp")
+	fs.StringVar(&c.Tracing.Endpoint, "tracing-endpoint", c.Tracing.Endpoint, "URL of the OTLP/HTTP collector")
+	fs.StringVar(&c.Tracing.File, "tracing-file", c.Tracing.File, "file the file exporter writes spans to")
+	fs.Float64Var(&c.Tracing.SampleRatio, "tracing-sample-ratio", c.Tracing.SampleRatio, "share of new traces that are recorded, from 0 to 1")
+}
+
+// EnvName returns the environment variable of a flag, e.g.
+// REDDITCLONE_READ_TIMEOUT for -read-timeout.
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration from the defaults, the config file,
+// the environment and the command-line arguments, and validates it.
+// It returns flag.ErrHelp if help was requested.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	// the config file is applied before flags, so find it first
+	var configPath string
+	scratch := Default()
+	fs := newFlagSet(&scratch, &configPath, io.Discard)
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return Config{}, err
+	}
+	if configPath == "" {
+		configPath = getenv(EnvName("config"))
+	}
+
+	cfg := Default()
+	if configPath != "" {
+		err = cfg.loadFile(configPath)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	fs = newFlagSet(&cfg, &configPath, os.Stderr)
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		value := getenv(EnvName(f.Name))
+		if value == "" || f.Name == "config" || envErr != nil {
+			return
+		}
+
+		err := fs.Set(f.Name, value)
+		if err != nil {
+			envErr = fmt.Errorf("invalid value %q for %s: %w", value, EnvName(f.Name), err)
+		}
+	})
+	if envErr != nil {
+		return Config{}, envErr
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func newFlagSet(cfg *Config, configPath *string, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("redditclone", flag.ContinueOnError)
+	fs.SetOutput(output)
+	cfg.bind(fs, configPath)
+	return fs
+}
+
+func (c *Config) loadFile(path string) error {
+	ext := filepath.Ext(path)
+	switch ext {
+	case ".yaml", ".yml", ".json", ".toml":
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q", path, ext)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	if ext == ".toml" {
+		err = c.decodeTOML(file)
+	} else {
+		err = c.decodeYAML(file)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// decodeYAML reads YAML and JSON, which is valid YAML, rejecting unknown
+// settings.
+func (c *Config) decodeYAML(r io.Reader) error {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	err := dec.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// decodeTOML reads TOML with the keys of the YAML file, rejecting unknown
+// settings.
+func (c *Config) decodeTOML(r io.Reader) error {
+	meta, err := toml.NewDecoder(r).Decode(c)
+	if err != nil {
+		return err
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) != 0 {
+		return fmt.Errorf("unknown setting %s", undecoded[0])
+	}
+
+	return nil
+}
+
+// Validate checks that the configuration is usable.
+func (c Config) Validate() error {
+	var errs []error
+
+	_, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+	if c.Metrics.Enabled && c.Metrics.Addr != "" {
+		_, _, err := net.SplitHostPort(c.Metrics.Addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("metrics.addr: %w", err))
+		}
+	}
+
+	if c.MaxBodyBytes <= 0 {
+		errs = append(errs, fmt.Errorf("maxBodyBytes must be positive, got %d", c.MaxBodyBytes))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"readTimeout", c.ReadTimeout},
+		{"writeTimeout", c.WriteTimeout},
+		{"idleTimeout", c.IdleTimeout},
+		{"shutdownTimeout", c.ShutdownTimeout},
+		{"jwt.ttl", c.JWT.TTL},
+		{"jwt.refreshTTL", c.JWT.RefreshTTL},
+		{"views.window", c.Views.Window},
+		{"views.flushInterval", c.Views.FlushInterval},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", d.name, d.value))
+		}
+	}
+
+	switch c.Storage.Backend {
+	case MemoryStorage:
+	case FileStorage:
+		if c.Storage.Path == "" {
+			errs = append(errs, errors.New("storage.path is required by the file backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.backend: unknown backend %q", c.Storage.Backend))
+	}
+
+	if c.Log.Format != LogText && c.Log.Format != LogJSON {
+		errs = append(errs, fmt.Errorf("log.format: unknown format %q", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case TraceNone, TraceStdout, TraceOTLP:
+	case TraceFile:
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing.file is required by the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: unknown exporter %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sampleRatio must be from 0 to 1, got %g", c.Tracing.SampleRatio))
+	}
+
+	errs = append(errs, c.JWT.validate()...)
+	errs = append(errs, c.RateLimit.validate()...)
+	if c.Lockout.Enabled {
+		errs = append(errs, c.Lockout.Account.validate("lockout.account")...)
+		errs = append(errs, c.Lockout.IP.validate("lockout.ip")...)
+	}
+
+	dirs := []struct {
+		name string
+		path string
+	}{
+		{"assets.htmlDir", c.Assets.HTMLDir},
+		{"assets.staticDir", c.Assets.StaticDir},
+	}
+	for _, dir := range dirs {
+		info, err := os.Stat(dir.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir.name, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a directory", dir.name, dir.path))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c JWTConfig) validate() []error {
+	var errs []error
+
+	if c.RefreshTTL < c.TTL {
+		errs = append(errs, errors.New("jwt.refreshTTL must not be shorter than jwt.ttl"))
+	}
+
+	ids := map[string]bool{}
+	if c.Secret != "" {
+		ids[DefaultJWTKey] = true
+		if len(c.Secret) < 16 {
+			errs = append(errs, errors.New("jwt.secret must be at least 16 bytes long"))
+		}
+	}
+
+	for i, key := range c.Keys {
+		name := fmt.Sprintf("jwt.keys[%d]", i)
+		switch {
+		case key.ID == "":
+			errs = append(errs, fmt.Errorf("%s: id is required", name))
+		case ids[key.ID]:
+			errs = append(errs, fmt.Errorf("%s: duplicate id %q", name, key.ID))
+		}
+		ids[key.ID] = true
+
+		if !slices.Contains(JWTAlgorithms, key.Algorithm) {
+			errs = append(errs, fmt.Errorf("%s: unknown algorithm %q, want one of %s",
+				name, key.Algorithm, strings.Join(JWTAlgorithms, ", ")))
+		}
+
+		switch {
+		case key.Secret != "" && key.File != "":
+			errs = append(errs, fmt.Errorf("%s: secret and file are mutually exclusive", name))
+		case key.Secret != "":
+			if key.Algorithm != "HS256" {
+				errs = append(errs, fmt.Errorf("%s: only HS256 keys can have an inline secret", name))
+			} else if len(key.Secret) < 16 {
+				errs = append(errs, fmt.Errorf("%s: secret must be at least 16 bytes long", name))
+			}
+		case key.File != "":
+			_, err := os.Stat(key.File)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: secret or file is required", name))
+		}
+	}
+
+	if len(ids) > 0 {
+		signingKey := c.SigningKey
+		if signingKey == "" {
+			signingKey = DefaultJWTKey
+		}
+		if !ids[signingKey] {
+			errs = append(errs, fmt.Errorf("jwt.signingKey: no key with id %q", signingKey))
+		}
+	} else if c.SigningKey != "" {
+		errs = append(errs, fmt.Errorf("jwt.signingKey: no key with id %q", c.SigningKey))
+	}
+
+	return errs
+}
+
+func (c RateLimitConfig) validate() []error {
+	if !c.Enabled {
+		return nil
+	}
+
+	limits := []struct {
+		name  string
+		limit RateLimit
+	}{
+		{"login", c.Login},
+		{"register", c.Register},
+		{"post", c.Post},
+		{"comment", c.Comment},
+		{"vote", c.Vote},
+	}
+
+	var errs []error
+	for _, l := range limits {
+		if l.limit.Requests <= 0 || l.limit.Period <= 0 {
+			errs = append(errs, fmt.Errorf("rateLimit.%s: requests and period must be positive, got %s", l.name, l.limit))
+		}
+		if l.limit.Burst < 0 {
+			errs = append(errs, fmt.Errorf("rateLimit.%s.burst must not be negative, got %d", l.name, l.limit.Burst))
+		}
+	}
+
+	return errs
+}
+
+func (p LockoutPolicy) validate(name string) []error {
+	var errs []error
+
+	if p.FreeAttempts < 0 {
+		errs = append(errs, fmt.Errorf("%s.freeAttempts must not be negative, got %d", name, p.FreeAttempts))
+	}
+	if p.LockoutAttempts <= p.FreeAttempts {
+		errs = append(errs, fmt.Errorf("%s.lockoutAttempts must be greater than freeAttempts, got %d", name, p.LockoutAttempts))
+	}
+	if p.Delay <= 0 || p.LockoutDuration <= 0 {
+		errs = append(errs, fmt.Errorf("%s: delay and lockoutDuration must be positive, got %s and %s", name, p.Delay, p.LockoutDuration))
+	}
+
+	return errs
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// assetArgs point the asset directories at an existing directory, so Load
+// passes validation.
+func assetArgs(t *testing.T) []string {
+	dir := t.TempDir()
+	return []string{"-assets-html-dir", dir, "-assets-static-dir", dir}
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "addr: :9001\nreadTimeout: 1m\n")
+
+	tests := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		wantAddr    string
+		wantTimeout time.Duration
+	}{
+		{
+			name:        "defaults",
+			wantAddr:    ":8081",
+			wantTimeout: 10 * time.Second,
+		},
+		{
+			name:        "file over defaults",
+			args:        []string{"-config", file},
+			wantAddr:    ":9001",
+			wantTimeout: time.Minute,
+		},
+		{
+			name:        "file from the environment",
+			env:         map[string]string{"REDDITCLONE_CONFIG": file},
+			wantAddr:    ":9001",
+			wantTimeout: time.Minute,
+		},
+		{
+			name:        "environment over file",
+			args:        []string{"-config", file},
+			env:         map[string]string{"REDDITCLONE_ADDR": ":9002"},
+			wantAddr:    ":9002",
+			wantTimeout: time.Minute,
+		},
+		{
+			name:        "flag over environment",
+			args:        []string{"-config", file, "-addr", ":9003"},
+			env:         map[string]string{"REDDITCLONE_ADDR": ":9002", "REDDITCLONE_READ_TIMEOUT": "2m"},
+			wantAddr:    ":9003",
+			wantTimeout: 2 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(append(assetArgs(t), tt.args...), env(tt.env))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Addr != tt.wantAddr {
+				t.Errorf("addr = %q, want %q", cfg.Addr, tt.wantAddr)
+			}
+			if cfg.ReadTimeout != tt.wantTimeout {
+				t.Errorf("readTimeout = %s, want %s", cfg.ReadTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "config.yaml", `
+storage:
+  backend: file
+  path: data.db
+jwt:
+  ttl: 5m
+rateLimit:
+  login:
+    requests: 3
+    period: 1m
+admins: [alice, bob]
+`},
+		{"yml", "config.yml", `
+storage: {backend: file, path: data.db}
+jwt: {ttl: 5m}
+rateLimit: {login: {requests: 3, period: 1m}}
+admins: [alice, bob]
+`},
+		{"toml", "config.toml", `
+admins = ["alice", "bob"]
+
+[storage]
+backend = "file"
+path = "data.db"
+
+[jwt]
+ttl = "5m"
+
+[rateLimit.login]
+requests = 3
+period = "1m"
+`},
+		{"json", "config.json", `{
+	"storage": {"backend": "file", "path": "data.db"},
+	"jwt": {"ttl": "5m"},
+	"rateLimit": {"login": {"requests": 3, "period": "1m"}},
+	"admins": ["alice", "bob"]
+}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.content)
+			cfg, err := Load(append(assetArgs(t), "-config", path), env(nil))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if cfg.Storage.Backend != FileStorage || cfg.Storage.Path != "data.db" {
+				t.Errorf("storage = %+v", cfg.Storage)
+			}
+			if cfg.JWT.TTL != 5*time.Minute {
+				t.Errorf("jwt.ttl = %s, want 5m", cfg.JWT.TTL)
+			}
+			if cfg.RateLimit.Login != (RateLimit{Requests: 3, Period: time.Minute}) {
+				t.Errorf("rateLimit.login = %+v", cfg.RateLimit.Login)
+			}
+			// settings missing from the file keep their defaults
+			if cfg.RateLimit.Vote != Default().RateLimit.Vote {
+				t.Errorf("rateLimit.vote = %+v, want the default", cfg.RateLimit.Vote)
+			}
+			if strings.Join(cfg.Admins, ",") != "alice,bob" {
+				t.Errorf("admins = %v", cfg.Admins)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"unsupported file format", []string{"-config", writeFile(t, "config.ini", "")}, nil, `unsupported format ".ini"`},
+		{"missing file", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, nil, "config file"},
+		{"unknown field", []string{"-config", writeFile(t, "config.yaml", "adress: :9000\n")}, nil, "field adress not found"},
+		{"unknown toml field", []string{"-config", writeFile(t, "config.toml", "[storage]\nbackend = \"file\"\nadress = \":9000\"\n")}, nil, "unknown setting storage.adress"},
+		{"malformed toml file", []string{"-config", writeFile(t, "config.toml", "addr = \n")}, nil, "config.toml"},
+		{"malformed file", []string{"-config", writeFile(t, "config.json", `{"addr": `)}, nil, "config.json"},
+		{"invalid environment value", nil, map[string]string{"REDDITCLONE_READ_TIMEOUT": "soon"}, "REDDITCLONE_READ_TIMEOUT"},
+		{"invalid flag value", []string{"-rate-limit-post", "many"}, nil, "rate-limit-post"},
+		{"unknown flag", []string{"-no-such-flag"}, nil, "no-such-flag"},
+		{"invalid config", []string{"-storage-backend", "cloud"}, nil, "invalid config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(append(assetArgs(t), tt.args...), env(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load: got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	_, err := Load([]string{"-h"}, env(nil))
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Load(-h): got %v, want %v", err, flag.ErrHelp)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"addr":               "REDDITCLONE_ADDR",
+		"read-timeout":       "REDDITCLONE_READ_TIMEOUT",
+		"rate-limit-comment": "REDDITCLONE_RATE_LIMIT_COMMENT",
+	}
+	for flagName, want := range tests {
+		if got := EnvName(flagName); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestRateLimitSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    RateLimit
+		wantErr string
+	}{
+		{value: "10/1m", want: RateLimit{Requests: 10, Period: time.Minute, Burst: 4}},
+		{value: "1/30s", want: RateLimit{Requests: 1, Period: 30 * time.Second, Burst: 4}},
+		{value: "10", wantErr: "REQUESTS/PERIOD"},
+		{value: "ten/1m", wantErr: "requests"},
+		{value: "10/minute", wantErr: "period"},
+	}
+	for _, tt := range tests {
+		l := RateLimit{Requests: 5, Period: time.Hour, Burst: 4}
+		err := l.Set(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Set(%q): got %v, want an error containing %q", tt.value, err, tt.wantErr)
+			}
+			if l != (RateLimit{Requests: 5, Period: time.Hour, Burst: 4}) {
+				t.Errorf("Set(%q) changed the limit to %+v on error", tt.value, l)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q): %v", tt.value, err)
+		}
+		if l != tt.want {
+			t.Errorf("Set(%q) = %+v, want %+v", tt.value, l, tt.want)
+		}
+	}
+
+	if got := (RateLimit{Requests: 10, Period: time.Minute}).String(); got != "10/1m0s" {
+		t.Errorf("String() = %q, want 10/1m0s", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writeFile(t, "key.pem", "")
+	valid := func() Config {
+		cfg := Default()
+		cfg.Assets.HTMLDir = dir
+		cfg.Assets.StaticDir = dir
+		return cfg
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate of the defaults: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"addr", func(c *Config) { c.Addr = "8081" }, "addr:"},
+		{"metrics addr", func(c *Config) { c.Metrics.Addr = "9090" }, "metrics.addr:"},
+		{"max body bytes", func(c *Config) { c.MaxBodyBytes = 0 }, "maxBodyBytes must be positive"},
+		{"read timeout", func(c *Config) { c.ReadTimeout = 0 }, "readTimeout must be positive"},
+		{"write timeout", func(c *Config) { c.WriteTimeout = -time.Second }, "writeTimeout must be positive"},
+		{"idle timeout", func(c *Config) { c.IdleTimeout = 0 }, "idleTimeout must be positive"},
+		{"shutdown timeout", func(c *Config) { c.ShutdownTimeout = 0 }, "shutdownTimeout must be positive"},
+		{"views window", func(c *Config) { c.Views.Window = 0 }, "views.window must be positive"},
+		{"views flush interval", func(c *Config) { c.Views.FlushInterval = 0 }, "views.flushInterval must be positive"},
+		{"storage backend", func(c *Config) { c.Storage.Backend = "cloud" }, `unknown backend "cloud"`},
+		{"storage path", func(c *Config) { c.Storage.Backend, c.Storage.Path = FileStorage, "" }, "storage.path is required"},
+		{"log format", func(c *Config) { c.Log.Format = "xml" }, `unknown format "xml"`},
+		{"trace exporter", func(c *Config) { c.Tracing.Exporter = "zipkin" }, `unknown exporter "zipkin"`},
+		{"trace file", func(c *Config) { c.Tracing.Exporter, c.Tracing.File = TraceFile, "" }, "tracing.file is required"},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sampleRatio must be from 0 to 1"},
+		{"html dir", func(c *Config) { c.Assets.HTMLDir = filepath.Join(dir, "missing") }, "assets.htmlDir:"},
+		{"static dir", func(c *Config) { c.Assets.StaticDir = keyFile }, "is not a directory"},
+
+		{"jwt ttl", func(c *Config) { c.JWT.TTL = 0 }, "jwt.ttl must be positive"},
+		{"jwt refresh ttl", func(c *Config) { c.JWT.RefreshTTL = time.Minute }, "jwt.refreshTTL must not be shorter"},
+		{"jwt secret", func(c *Config) { c.JWT.Secret = "short" }, "jwt.secret must be at least 16 bytes"},
+		{"jwt key id", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{Algorithm: "HS256", Secret: "0123456789abcdef"}}
+		}, "jwt.keys[0]: id is required"},
+		{"jwt duplicate key", func(c *Config) {
+			c.JWT.Secret = "0123456789abcdef"
+			c.JWT.Keys = []JWTKey{{ID: DefaultJWTKey, Algorithm: "HS256", Secret: "0123456789abcdef"}}
+		}, `jwt.keys[0]: duplicate id "default"`},
+		{"jwt algorithm", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "none", File: keyFile}}
+			c.JWT.SigningKey = "k1"
+		}, `unknown algorithm "none"`},
+		{"jwt secret and file", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "HS256", Secret: "0123456789abcdef", File: keyFile}}
+			c.JWT.SigningKey = "k1"
+		}, "secret and file are mutually exclusive"},
+		{"jwt inline secret of an asymmetric key", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "RS256", Secret: "0123456789abcdef"}}
+			c.JWT.SigningKey = "k1"
+		}, "only HS256 keys can have an inline secret"},
+		{"jwt short key secret", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "HS256", Secret: "short"}}
+			c.JWT.SigningKey = "k1"
+		}, "jwt.keys[0]: secret must be at least 16 bytes"},
+		{"jwt key file", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "EdDSA", File: filepath.Join(dir, "missing.pem")}}
+			c.JWT.SigningKey = "k1"
+		}, "jwt.keys[0]:"},
+		{"jwt key material", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "HS256"}}
+			c.JWT.SigningKey = "k1"
+		}, "secret or file is required"},
+		{"jwt signing key of the keys", func(c *Config) {
+			c.JWT.Keys = []JWTKey{{ID: "k1", Algorithm: "EdDSA", File: keyFile}}
+		}, `jwt.signingKey: no key with id "default"`},
+		{"jwt signing key without keys", func(c *Config) { c.JWT.SigningKey = "k1" }, `jwt.signingKey: no key with id "k1"`},
+
+		{"rate limit requests", func(c *Config) { c.RateLimit.Login.Requests = 0 }, "rateLimit.login: requests and period must be positive"},
+		{"rate limit period", func(c *Config) { c.RateLimit.Vote.Period = 0 }, "rateLimit.vote: requests and period must be positive"},
+		{"rate limit burst", func(c *Config) { c.RateLimit.Post.Burst = -1 }, "rateLimit.post.burst must not be negative"},
+
+		{"lockout free attempts", func(c *Config) { c.Lockout.Account.FreeAttempts = -1 }, "lockout.account.freeAttempts must not be negative"},
+		{"lockout attempts", func(c *Config) { c.Lockout.IP.LockoutAttempts = c.Lockout.IP.FreeAttempts }, "lockout.ip.lockoutAttempts must be greater"},
+		{"lockout delay", func(c *Config) { c.Lockout.Account.Delay = 0 }, "lockout.account: delay and lockoutDuration must be positive"},
+		{"lockout duration", func(c *Config) { c.Lockout.IP.LockoutDuration = 0 }, "lockout.ip: delay and lockoutDuration must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate: got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// disabled features are not validated
+	cfg := valid()
+	cfg.RateLimit.Enabled = false
+	cfg.RateLimit.Login = RateLimit{}
+	cfg.Lockout.Enabled = false
+	cfg.Lockout.Account = LockoutPolicy{}
+	cfg.Metrics.Enabled = false
+	cfg.Metrics.Addr = "9090"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate with disabled rate limits, lockouts and metrics: %v", err)
+	}
+}
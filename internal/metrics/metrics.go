@@ -0,0 +1,164 @@
+// Package metrics collects request, business and storage metrics and
+// exposes them in the Prometheus text format. A nil *Metrics records
+// nothing, so handlers can use it unconditionally.
+package metrics
+
+import (
+	"net/http"
+	"redditclone/internal/storage"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "redditclone"
+
+// Vote directions.
+const (
+	Up   = "up"
+	Down = "down"
+	// None is the removal of a vote.
+	None = "none"
+)
+
+// Storage is what the storage gauges are read from.
+type Storage interface {
+	CountUsers() int
+	GetPosts() []storage.Post
+	GetCommunities() []storage.Community
+}
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	registrations prometheus.Counter
+	logins        *prometheus.CounterVec
+	posts         prometheus.Counter
+	comments      prometheus.Counter
+	votes         *prometheus.CounterVec
+}
+
+// New creates the metrics of the service, with gauges read from s on
+// every scrape.
+func New(s Storage) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "API requests by method, route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of API requests by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Registered users.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by result, success or failure.",
+		}, []string{"result"}),
+		posts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "posts_created_total",
+			Help:      "Created posts.",
+		}),
+		comments: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "comments_created_total",
+			Help:      "Created comments and replies.",
+		}),
+		votes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "votes_total",
+			Help:      "Votes on posts and comments by direction, none for removed votes.",
+		}, []string{"direction"}),
+	}
+
+	// results and directions are known up front, so they are exported
+	// before they first happen
+	m.logins.WithLabelValues("success")
+	m.logins.WithLabelValues("failure")
+	for _, direction := range []string{Up, Down, None} {
+		m.votes.WithLabelValues(direction)
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.duration,
+		m.registrations, m.logins, m.posts, m.comments, m.votes,
+		newStorageCollector(s),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a served API request.
+func (m *Metrics) ObserveRequest(method, route string, status int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(method, route).Observe(latency.Seconds())
+}
+
+func (m *Metrics) Registered() {
+	if m == nil {
+		return
+	}
+	m.registrations.Inc()
+}
+
+// LoggedIn records a login attempt with a valid or invalid password.
+func (m *Metrics) LoggedIn(ok bool) {
+	if m == nil {
+		return
+	}
+
+	result := "failure"
+	if ok {
+		result = "success"
+	}
+	m.logins.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) PostCreated() {
+	if m == nil {
+		return
+	}
+	m.posts.Inc()
+}
+
+func (m *Metrics) CommentCreated() {
+	if m == nil {
+		return
+	}
+	m.comments.Inc()
+}
+
+// Voted records a vote in the direction Up, Down or None.
+func (m *Metrics) Voted(direction string) {
+	if m == nil {
+		return
+	}
+	m.votes.WithLabelValues(direction).Inc()
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"redditclone/internal/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	s := storage.NewInMemStorage()
+	s.AddCommunity(storage.Community{Name: "news"})
+	s.AddUser("alice", "secret")
+	post, _ := s.AddPost(storage.RawPost{Type: storage.TEXT, Category: "news", Title: "title", Content: "text"}, "alice", "1")
+	s.AddComment(post.ID, "1", "alice", "first")
+
+	m := New(s)
+	m.ObserveRequest("GET", "/api/post/{id}", http.StatusOK, 30*time.Millisecond)
+	m.Registered()
+	m.LoggedIn(true)
+	m.LoggedIn(false)
+	m.LoggedIn(false)
+	m.PostCreated()
+	m.CommentCreated()
+	m.Voted(Up)
+
+	body := scrape(t, m)
+	for _, line := range []string{
+		`redditclone_http_requests_total{method="GET",route="/api/post/{id}",status="200"} 1`,
+		`redditclone_http_request_duration_seconds_bucket{method="GET",route="/api/post/{id}",le="0.05"} 1`,
+		`redditclone_registrations_total 1`,
+		`redditclone_logins_total{result="failure"} 2`,
+		`redditclone_logins_total{result="success"} 1`,
+		`redditclone_posts_created_total 1`,
+		`redditclone_comments_created_total 1`,
+		`redditclone_votes_total{direction="down"} 0`,
+		`redditclone_votes_total{direction="up"} 1`,
+		`redditclone_storage_users 1`,
+		`redditclone_storage_posts 1`,
+		`redditclone_storage_comments 1`,
+		// the authors upvote their post and comment
+		`redditclone_storage_votes 2`,
+		`redditclone_storage_communities 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("GET", "/", http.StatusOK, time.Second)
+	m.Registered()
+	m.LoggedIn(true)
+	m.PostCreated()
+	m.CommentCreated()
+	m.Voted(Down)
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storageCollector reports how much the storage holds. It counts on every
+// scrape, so the gauges never drift from the data.
+type storageCollector struct {
+	storage     Storage
+	users       *prometheus.Desc
+	posts       *prometheus.Desc
+	comments    *prometheus.Desc
+	votes       *prometheus.Desc
+	communities *prometheus.Desc
+}
+
+func newStorageCollector(s Storage) *storageCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "storage", name), help, nil, nil)
+	}
+
+	return &storageCollector{
+		storage:     s,
+		users:       desc("users", "Registered users."),
+		posts:       desc("posts", "Stored posts, including removed ones."),
+		comments:    desc("comments", "Stored comments, including deleted ones that have replies."),
+		votes:       desc("votes", "Votes on stored posts and comments."),
+		communities: desc("communities", "Communities."),
+	}
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.users
+	ch <- c.posts
+	ch <- c.comments
+	ch <- c.votes
+	ch <- c.communities
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	posts := c.storage.GetPosts()
+
+	var comments, votes int
+	for _, post := range posts {
+		comments += len(post.Comments)
+		votes += len(post.Votes)
+		for _, comment := range post.Comments {
+			votes += len(comment.Votes)
+		}
+	}
+
+	gauge := func(desc *prometheus.Desc, value int) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value))
+	}
+	gauge(c.users, c.storage.CountUsers())
+	gauge(c.posts, len(posts))
+	gauge(c.comments, comments)
+	gauge(c.votes, votes)
+	gauge(c.communities, len(c.storage.GetCommunities()))
+}
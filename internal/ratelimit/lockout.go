@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy throttles repeated failures, e.g. failed logins. The first
+// FreeAttempts failures cost nothing, every further one doubles the wait
+// before the next attempt, starting at Delay. LockoutAttempts failures
+// lock the key for LockoutDuration. Failures are forgotten LockoutDuration
+// after the last one.
+type Policy struct {
+	FreeAttempts    int
+	Delay           time.Duration
+	LockoutAttempts int
+	LockoutDuration time.Duration
+}
+
+// wait returns how long after the last of n failures attempts are refused.
+func (p Policy) wait(n int) time.Duration {
+	switch {
+	case n >= p.LockoutAttempts:
+		return p.LockoutDuration
+	case n <= p.FreeAttempts:
+		return 0
+	}
+
+	// the shift is capped, the delay would exceed the lockout long before
+	shift := min(n-p.FreeAttempts-1, 30)
+	return min(p.Delay<<shift, p.LockoutDuration)
+}
+
+// Lockout tracks failures per key in memory. A nil Lockout never refuses
+// an attempt.
+type Lockout struct {
+	policy Policy
+
+	mu        sync.Mutex
+	failures  map[string]*failures
+	lastSweep time.Time
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	// pending attempts are reserved but not decided yet, they are
+	// throttled like failures since the last reservation
+	pending  int
+	reserved time.Time
+}
+
+// since returns when the wait before the next attempt starts.
+func (f *failures) since() time.Time {
+	if f.pending > 0 && f.reserved.After(f.last) {
+		return f.reserved
+	}
+	return f.last
+}
+
+func NewLockout(policy Policy) *Lockout {
+	return &Lockout{policy: policy, failures: map[string]*failures{}}
+}
+
+// Wait returns how long the key has to wait before its next attempt.
+func (l *Lockout) Wait(key string, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.wait(l.get(key, now), now)
+}
+
+func (l *Lockout) wait(f *failures, now time.Time) time.Duration {
+	if f == nil {
+		return 0
+	}
+	return max(0, f.since().Add(l.policy.wait(f.count+f.pending)).Sub(now))
+}
+
+// Attempt reserves an attempt of the key, unless it has to wait. Until it
+// is decided by Fail, Reset or Release, the attempt is throttled like a
+// failure, so concurrent attempts can not get past the lockout.
+func (l *Lockout) Attempt(key string, now time.Time) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	f := l.get(key, now)
+	if wait := l.wait(f, now); wait > 0 {
+		return wait, false
+	}
+	if f == nil {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.pending++
+	f.reserved = now
+	return 0, true
+}
+
+// Fail records a reserved attempt of the key as failed.
+func (l *Lockout) Fail(key string, now time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	f, ok := l.failures[key]
+	if !ok {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.pending = max(0, f.pending-1)
+	f.count++
+	f.last = now
+}
+
+// Release takes back a reserved attempt of the key that did not fail, the
+// earlier failures still count.
+func (l *Lockout) Release(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok {
+		return
+	}
+	f.pending = max(0, f.pending-1)
+	if f.count == 0 && f.pending == 0 {
+		delete(l.failures, key)
+	}
+}
+
+// Reset forgets the failures of the key after a successful attempt.
+func (l *Lockout) Reset(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+}
+
+// get returns the failures of the key unless they are forgotten.
+func (l *Lockout) get(key string, now time.Time) *failures {
+	f, ok := l.failures[key]
+	if !ok {
+		return nil
+	}
+	if f.pending == 0 && now.Sub(f.last) >= l.policy.LockoutDuration {
+		delete(l.failures, key)
+		return nil
+	}
+	return f
+}
+
+// sweep drops forgotten failures, at most once per sweepInterval.
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key := range l.failures {
+		l.get(key, now)
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are dropped from a MemoryStore.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets of a single instance in memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket refills, from then on it is the same as
+	// a missing one
+	full time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(keys []string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	rate, burst := limit.rate(), limit.burst()
+	buckets := make([]*bucket, len(keys))
+	allowed, retryAfter := true, time.Duration(0)
+	for i, key := range keys {
+		b, ok := s.buckets[key]
+		if !ok {
+			b = &bucket{tokens: burst, updated: now}
+			s.buckets[key] = b
+		}
+
+		b.tokens = min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+		b.updated = now
+		if b.tokens < 1 {
+			allowed = false
+			retryAfter = max(retryAfter, seconds((1-b.tokens)/rate))
+		}
+		buckets[i] = b
+	}
+
+	for _, b := range buckets {
+		if allowed {
+			b.tokens--
+		}
+		b.full = now.Add(seconds((burst - b.tokens) / rate))
+	}
+
+	return allowed, retryAfter, nil
+}
+
+// sweep drops full buckets, at most once per sweepInterval.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
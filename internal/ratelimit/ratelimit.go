@@ -0,0 +1,109 @@
+// Package ratelimit limits request rates with token buckets. Every key,
+// e.g. an IP address or a user, gets its own bucket per budget. Buckets
+// are kept in a Store, so instances can share them.
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limit allows Requests per Period on average and bursts of up to Burst
+// requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	// Burst defaults to Requests.
+	Burst int
+}
+
+// rate returns the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// Store keeps token buckets.
+type Store interface {
+	// Take takes a token from the bucket of every key if none of them is
+	// empty. Otherwise it takes no token and returns false and how long
+	// until all of them have one.
+	Take(keys []string, limit Limit, now time.Time) (ok bool, retryAfter time.Duration, err error)
+}
+
+// Limiter is a budget of requests. A nil Limiter allows everything.
+type Limiter struct {
+	name  string
+	limit Limit
+	store Store
+}
+
+// New creates the limiter of a budget. The name separates its buckets
+// from those of other budgets in the store.
+func New(name string, limit Limit, store Store) *Limiter {
+	return &Limiter{name: name, limit: limit, store: store}
+}
+
+// Allow takes a token from the bucket of every key if all of them have
+// one. A refused request takes no tokens, so it does not drain the other
+// buckets of the client. A failing store allows the request.
+func (l *Limiter) Allow(keys ...string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = l.name + ":" + key
+	}
+
+	ok, retryAfter, err := l.store.Take(names, l.limit, time.Now())
+	if err != nil {
+		slog.Error("rate limit store failed", "limit", l.name, "err", err)
+		return true, 0
+	}
+
+	return ok, retryAfter
+}
+
+// Handler refuses requests to next when any of the buckets returned by
+// keys is empty. refuse writes the response of refused requests, by
+// default a plain 429 Too Many Requests.
+func (l *Limiter) Handler(keys func(r *http.Request) []string, refuse func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration), next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	if refuse == nil {
+		refuse = func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+			SetRetryAfter(w.Header(), retryAfter)
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := l.Allow(keys(r)...)
+		if !ok {
+			refuse(w, r, retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetRetryAfter sets the Retry-After header to the wait in whole seconds,
+// at least one, and returns the seconds.
+func SetRetryAfter(h http.Header, retryAfter time.Duration) int {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	h.Set("Retry-After", strconv.Itoa(seconds))
+	return seconds
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Requests: 2, Period: time.Minute, Burst: 3}
+	now := time.Now()
+
+	for i := range 3 {
+		if ok, _, _ := s.Take([]string{"alice"}, limit, now); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	ok, retryAfter, _ := s.Take([]string{"alice"}, limit, now)
+	if ok || retryAfter != 30*time.Second {
+		t.Errorf("Take of empty bucket = %v, %s, want false, 30s", ok, retryAfter)
+	}
+	if ok, _, _ = s.Take([]string{"bob"}, limit, now); !ok {
+		t.Errorf("bucket of another key is empty")
+	}
+
+	if ok, _, _ = s.Take([]string{"alice"}, limit, now.Add(30*time.Second)); !ok {
+		t.Errorf("bucket was not refilled")
+	}
+	if ok, _, _ = s.Take([]string{"alice"}, limit, now.Add(30*time.Second)); ok {
+		t.Errorf("bucket was refilled by more than one token")
+	}
+
+	// a refused request takes no token from the keys that had one
+	if ok, _, _ = s.Take([]string{"dave", "alice"}, limit, now.Add(30*time.Second)); ok {
+		t.Errorf("request with an empty bucket was allowed")
+	}
+	for i := range 3 {
+		if ok, _, _ := s.Take([]string{"dave"}, limit, now.Add(30*time.Second)); !ok {
+			t.Errorf("request %d of dave was refused after a refusal by alice", i+1)
+		}
+	}
+
+	// full buckets are dropped
+	s.Take([]string{"carol"}, limit, now.Add(2*time.Minute))
+	if _, ok := s.buckets["bob"]; ok {
+		t.Errorf("full bucket was kept")
+	}
+	if _, ok := s.buckets["carol"]; !ok {
+		t.Errorf("used bucket was dropped")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	l := New("login", Limit{Requests: 1, Period: time.Hour}, NewMemoryStore())
+	h := l.Handler(func(r *http.Request) []string {
+		return []string{r.Header.Get("X-Key")}
+	}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.Header.Set("X-Key", key)
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("a"); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	w := do("a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("second request: status %d, Retry-After %q, want 429, 3600", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w = do("b"); w.Code != http.StatusOK {
+		t.Errorf("request of another key: status %d", w.Code)
+	}
+
+	var unlimited *Limiter
+	if ok, _ := unlimited.Allow("a"); !ok {
+		t.Errorf("nil limiter refused a request")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(Policy{FreeAttempts: 2, Delay: time.Second, LockoutAttempts: 5, LockoutDuration: time.Minute})
+	now := time.Now()
+
+	waits := []time.Duration{0, 0, time.Second, 2 * time.Second, time.Minute}
+	for i, want := range waits {
+		l.Fail("alice", now)
+		if got := l.Wait("alice", now); got != want {
+			t.Errorf("wait after %d failures = %s, want %s", i+1, got, want)
+		}
+	}
+	if got := l.Wait("alice", now.Add(40*time.Second)); got != 20*time.Second {
+		t.Errorf("wait during lockout = %s, want 20s", got)
+	}
+	if got := l.Wait("bob", now); got != 0 {
+		t.Errorf("wait of another key = %s, want 0", got)
+	}
+
+	// failures are forgotten after the lockout
+	l.Fail("alice", now.Add(time.Minute))
+	if got := l.Wait("alice", now.Add(time.Minute)); got != 0 {
+		t.Errorf("wait after the lockout ended = %s, want 0", got)
+	}
+
+	l.Fail("bob", now)
+	l.Fail("bob", now)
+	l.Fail("bob", now)
+	l.Reset("bob")
+	if got := l.Wait("bob", now); got != 0 {
+		t.Errorf("wait after reset = %s, want 0", got)
+	}
+
+	var none *Lockout
+	none.Fail("alice", now)
+	if got := none.Wait("alice", now); got != 0 {
+		t.Errorf("nil lockout wait = %s, want 0", got)
+	}
+}
+
+func TestLockoutAttempt(t *testing.T) {
+	l := NewLockout(Policy{FreeAttempts: 1, Delay: time.Second, LockoutAttempts: 3, LockoutDuration: time.Minute})
+	now := time.Now()
+
+	// pending attempts are throttled like failures
+	for i := range 2 {
+		if _, ok := l.Attempt("alice", now); !ok {
+			t.Fatalf("attempt %d was refused", i+1)
+		}
+	}
+	if wait, ok := l.Attempt("alice", now); ok || wait != time.Second {
+		t.Errorf("third attempt = %s, %t, want 1s, false", wait, ok)
+	}
+
+	// released attempts do not count, failed ones do
+	l.Release("alice")
+	l.Fail("alice", now)
+	if got := l.Wait("alice", now); got != 0 {
+		t.Errorf("wait after a failure and a release = %s, want 0", got)
+	}
+	if _, ok := l.Attempt("alice", now); !ok {
+		t.Error("attempt after a release was refused")
+	}
+	l.Fail("alice", now)
+	if got := l.Wait("alice", now); got != time.Second {
+		t.Errorf("wait after two failures = %s, want 1s", got)
+	}
+
+	var none *Lockout
+	if _, ok := none.Attempt("alice", now); !ok {
+		t.Error("nil lockout refused an attempt")
+	}
+	none.Release("alice")
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	policy := Policy{FreeAttempts: 2, Delay: time.Second, LockoutAttempts: 5, LockoutDuration: time.Minute}
+	l := NewLockout(policy)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for range 4 * policy.LockoutAttempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.Attempt("alice", now); ok {
+				allowed.Add(1)
+				l.Fail("alice", now)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := int(allowed.Load()); got > policy.LockoutAttempts {
+		t.Errorf("%d concurrent attempts allowed, want at most %d", got, policy.LockoutAttempts)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"redditclone/internal/authz"
+	"redditclone/internal/storage"
+	"strconv"
+)
+
+// subject returns the authenticated user of the request with the
+// communities it moderates.
+func subject(r *http.Request, communities storage.CommunityStorage) authz.Subject {
+	user := r.Context().Value(USER).(UserClaims)
+	return authz.Subject{
+		ID:        user.ID,
+		Name:      user.Name,
+		Role:      user.Role,
+		Moderates: traced(r.Context(), communities).ModeratedCommunities(user.ID),
+	}
+}
+
+// audit records a privileged action of the actor. The action has already
+// happened, so a failure to record it is only logged.
+func audit(r *http.Request, s storage.AuditStorage, actor authz.Subject, action authz.Action, community, target, details string) {
+	role := "moderator"
+	if actor.Role == authz.RoleAdmin {
+		role = string(authz.RoleAdmin)
+	}
+
+	_, err := traced(r.Context(), s).AddAuditEntry(storage.AuditEntry{
+		Actor:     storage.PostAuthor{Name: actor.Name, ID: actor.ID},
+		Role:      role,
+		Action:    action,
+		Community: community,
+		Target:    target,
+		Details:   details,
+	})
+	if err != nil {
+		requestLogger(r).Error("could not record audit entry",
+			"action", action, "target", target, "actor", actor.Name, "err", err)
+	}
+}
+
+type AdminHandler struct {
+	Storage storage.Storage
+}
+
+func NewAdminHandler(storage storage.Storage) *AdminHandler {
+	return &AdminHandler{Storage: storage}
+}
+
+// handleGetAuditLog returns the audit log, newest entries first. The
+// optional limit parameter caps the number of entries.
+func (h *AdminHandler) handleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !authz.Can(subject(r, h.Storage), authz.ViewAuditLog, authz.Resource{}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	entries := h.store(r).GetAuditLog()
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			jsonError(w, r, http.StatusBadRequest, []RequestError{{
+				Location: "query",
+				Param:    "limit",
+				Value:    value,
+				Message:  "must be a positive number",
+			}})
+			return
+		}
+		entries = entries[:min(limit, len(entries))]
+	}
+
+	writeJSON(w, r, http.StatusOK, entries)
+}
+
+type SetRoleRequest struct {
+	Role authz.Role `json:"role"`
+}
+
+// handleSetUserRole changes the site-wide role of a user. A demoted user
+// is logged out of all sessions, a promotion takes effect with the next
+// token refresh.
+func (h *AdminHandler) handleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	if !authz.Can(actor, authz.ManageUsers, authz.Resource{}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	var req SetRoleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDecodeError(w, r, err, "wrong request body, role expected")
+		return
+	}
+	if !req.Role.Valid() {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "role",
+			Value:    string(req.Role),
+			Message:  "unknown role",
+		}})
+		return
+	}
+
+	username := r.PathValue("username")
+	before, err := h.store(r).FindUser(username)
+	if err == nil {
+		_, err = h.store(r).SetUserRole(username, req.Role)
+	}
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeError(w, r, http.StatusNotFound, "user not found")
+		return
+	}
+	if err == nil && before.Role == authz.RoleAdmin && req.Role != authz.RoleAdmin {
+		err = h.store(r).DeleteUserSessions(before.ID)
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("change role: %w", err))
+		return
+	}
+
+	audit(r, h.Storage, actor, authz.ManageUsers, "", username, "role "+string(req.Role))
+
+	writeJSON(w, r, http.StatusOK, UserClaims{ID: before.ID, Name: before.Name, Role: req.Role})
+}
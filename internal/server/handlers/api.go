@@ -1,28 +1,127 @@
 package handlers
 
 import (
+	"net"
 	"net/http"
+	"redditclone/internal/metrics"
+	"redditclone/internal/ratelimit"
 	"redditclone/internal/storage"
+	"redditclone/internal/views"
+	"time"
 )
 
-func ReqisterAPIHandlers(mux *http.ServeMux, storage storage.Storage) {
-	userHandler := NewUserHandler(storage)
-	postHandler := NewPostHandler(storage)
+// Options holds the dependencies of the API handlers.
+type Options struct {
+	Storage    storage.Storage
+	Views      *views.Counter
+	Tokens     *Tokens
+	RateLimits RateLimits
+	Lockouts   Lockouts
+	// MaxBodyBytes limits the size of request bodies, 0 means no limit.
+	MaxBodyBytes int64
+	// ProblemDetails writes every error as an RFC 7807 problem document,
+	// otherwise only clients that accept application/problem+json get them.
+	ProblemDetails bool
+	// Metrics records the API requests and what they changed, nil
+	// disables it.
+	Metrics *metrics.Metrics
+}
+
+// RateLimits are the request budgets of the API. A nil limiter allows
+// every request.
+type RateLimits struct {
+	Login    *ratelimit.Limiter
+	Register *ratelimit.Limiter
+	Post     *ratelimit.Limiter
+	Comment  *ratelimit.Limiter
+	Vote     *ratelimit.Limiter
+}
+
+// rateLimitKeys are the buckets a request draws from: its IP address and,
+// behind withAuth, its user.
+func rateLimitKeys(r *http.Request) []string {
+	keys := []string{"ip:" + clientIP(r)}
+	if user, ok := r.Context().Value(USER).(UserClaims); ok {
+		keys = append(keys, "user:"+user.ID)
+	}
+	return keys
+}
+
+// clientIP is the peer address of the connection. Forwarding headers are
+// not trusted, so behind a reverse proxy all clients share its address.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func ReqisterAPIHandlers(mux *http.ServeMux, opts Options) {
+	userHandler := NewUserHandler(opts.Storage, opts.Tokens, opts.Lockouts, opts.Metrics)
+	postHandler := NewPostHandler(opts.Storage, opts.Views, opts.Tokens, opts.Metrics)
+	communityHandler := NewCommunityHandler(opts.Storage)
+	adminHandler := NewAdminHandler(opts.Storage)
+	moderationHandler := NewModerationHandler(opts.Storage)
+	withAuth := opts.Tokens.withAuth
+	// writes are refused to suspended users
+	withWrite := func(h http.Handler) http.Handler {
+		return withAuth(withActive(h))
+	}
+	limits := opts.RateLimits
+	limit := func(l *ratelimit.Limiter, h http.HandlerFunc) http.Handler {
+		return l.Handler(rateLimitKeys, func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+			writeTooManyRequests(w, r, retryAfter, "too many requests")
+		}, h)
+	}
 
 	apiMux := http.NewServeMux()
-	apiMux.HandleFunc("POST /register", userHandler.handleRegister)
-	apiMux.HandleFunc("POST /login", userHandler.handleLogIn)
+	apiMux.Handle("POST /register", limit(limits.Register, userHandler.handleRegister))
+	apiMux.Handle("POST /login", limit(limits.Login, userHandler.handleLogIn))
+	apiMux.HandleFunc("POST /token/refresh", userHandler.handleRefresh)
+	apiMux.Handle("POST /logout", withAuth(http.HandlerFunc(userHandler.handleLogOut)))
 	apiMux.HandleFunc("GET /posts/", postHandler.handleGetPosts)
 	apiMux.HandleFunc("GET /posts/{category}", postHandler.handleGetCategoryPosts)
+	apiMux.HandleFunc("GET /communities", communityHandler.handleGetCommunities)
+	apiMux.HandleFunc("GET /community/{name}", communityHandler.handleGetCommunity)
+	apiMux.Handle("POST /communities", withWrite(http.HandlerFunc(communityHandler.handleNewCommunity)))
+	apiMux.Handle("POST /community/{name}/moderators", withAuth(http.HandlerFunc(communityHandler.handleAddModerator)))
+	apiMux.Handle("DELETE /community/{name}/moderators/{username}", withAuth(http.HandlerFunc(communityHandler.handleRemoveModerator)))
+	apiMux.Handle("GET /modqueue", withAuth(http.HandlerFunc(moderationHandler.handleGetModQueue)))
+	apiMux.Handle("POST /modqueue/{postID}/{action}", withAuth(http.HandlerFunc(moderationHandler.handleModerate)))
+	apiMux.Handle("POST /modqueue/{postID}/{commentID}/{action}", withAuth(http.HandlerFunc(moderationHandler.handleModerate)))
+	apiMux.Handle("GET /admin/audit", withAuth(http.HandlerFunc(adminHandler.handleGetAuditLog)))
+	apiMux.Handle("PUT /admin/users/{username}/role", withAuth(http.HandlerFunc(adminHandler.handleSetUserRole)))
+	apiMux.Handle("PUT /admin/users/{username}/ban", withAuth(http.HandlerFunc(adminHandler.handleBanUser)))
+	apiMux.Handle("DELETE /admin/users/{username}/ban", withAuth(http.HandlerFunc(adminHandler.handleUnbanUser)))
 	apiMux.HandleFunc("GET /user/{username}", postHandler.handleGetUserPosts)
 	apiMux.HandleFunc("GET /post/{id}", postHandler.handleGetPostDetails)
-	apiMux.Handle("POST /posts", withAuth(http.HandlerFunc(postHandler.handleNewPost)))
-	apiMux.Handle("DELETE /post/{id}", withAuth(http.HandlerFunc(postHandler.handleDeletePost)))
-	apiMux.Handle("GET /post/{id}/upvote", withAuth(http.HandlerFunc(postHandler.handleUpvote)))
-	apiMux.Handle("GET /post/{id}/downvote", withAuth(http.HandlerFunc(postHandler.handleDownvote)))
-	apiMux.Handle("GET /post/{id}/unvote", withAuth(http.HandlerFunc(postHandler.handleUnvote)))
-	apiMux.Handle("POST /post/{id}", withAuth(http.HandlerFunc(postHandler.handleAddComment)))
-	apiMux.Handle("DELETE /post/{postID}/{commentID}", withAuth(http.HandlerFunc(postHandler.handleDeleteComment)))
-
-	mux.Handle("/api/", http.StripPrefix("/api", apiMux))
+	apiMux.HandleFunc("GET /post/{id}/comments", postHandler.handleGetComments)
+	apiMux.Handle("POST /posts", withWrite(limit(limits.Post, postHandler.handleNewPost)))
+	apiMux.Handle("DELETE /post/{id}", withWrite(http.HandlerFunc(postHandler.handleDeletePost)))
+	apiMux.Handle("PUT /post/{id}", withWrite(http.HandlerFunc(postHandler.handleEditPost)))
+	apiMux.Handle("PATCH /post/{id}", withWrite(http.HandlerFunc(postHandler.handleEditPost)))
+	apiMux.Handle("GET /post/{id}/revisions", withAuth(http.HandlerFunc(postHandler.handleGetPostRevisions)))
+	apiMux.Handle("GET /post/{id}/upvote", withWrite(limit(limits.Vote, postHandler.handleUpvote)))
+	apiMux.Handle("GET /post/{id}/downvote", withWrite(limit(limits.Vote, postHandler.handleDownvote)))
+	apiMux.Handle("GET /post/{id}/unvote", withWrite(limit(limits.Vote, postHandler.handleUnvote)))
+	apiMux.Handle("POST /post/{id}", withWrite(limit(limits.Comment, postHandler.handleAddComment)))
+	apiMux.Handle("POST /post/{postID}/{commentID}", withWrite(limit(limits.Comment, postHandler.handleAddReply)))
+	apiMux.Handle("POST /post/{id}/report", withWrite(http.HandlerFunc(moderationHandler.handleReportPost)))
+	apiMux.Handle("POST /post/{postID}/{commentID}/report", withWrite(http.HandlerFunc(moderationHandler.handleReportComment)))
+	apiMux.Handle("GET /post/{postID}/{commentID}/upvote", withWrite(limit(limits.Vote, postHandler.handleCommentUpvote)))
+	apiMux.Handle("GET /post/{postID}/{commentID}/downvote", withWrite(limit(limits.Vote, postHandler.handleCommentDownvote)))
+	apiMux.Handle("GET /post/{postID}/{commentID}/unvote", withWrite(limit(limits.Vote, postHandler.handleCommentUnvote)))
+	apiMux.Handle("DELETE /post/{postID}/{commentID}", withWrite(http.HandlerFunc(postHandler.handleDeleteComment)))
+	apiMux.Handle("PUT /post/{postID}/{commentID}", withWrite(http.HandlerFunc(postHandler.handleEditComment)))
+	apiMux.Handle("PATCH /post/{postID}/{commentID}", withWrite(http.HandlerFunc(postHandler.handleEditComment)))
+	apiMux.Handle("GET /post/{postID}/{commentID}/revisions", withAuth(http.HandlerFunc(postHandler.handleGetCommentRevisions)))
+	apiMux.HandleFunc(unmatchedPattern, unmatched(apiMux))
+
+	route := func(r *http.Request) string {
+		return routeOf("/api", apiMux, r)
+	}
+	api := withMetrics(opts.Metrics, route, withTracing(route, Routed("/api", apiMux)))
+	mux.Handle("/api/", http.StripPrefix("/api", withErrorFormat(opts.ProblemDetails, limitBody(opts.MaxBodyBytes, api))))
+	mux.HandleFunc("GET /.well-known/jwks.json", opts.Tokens.keys.handleJWKS)
 }
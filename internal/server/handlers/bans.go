@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"redditclone/internal/authz"
+	"redditclone/internal/storage"
+	"time"
+)
+
+const BAN key = "ban"
+
+// BanNotice is the body of errors of restricted users, it tells them why
+// a request was refused. Shadowbans are never reported.
+type BanNotice struct {
+	Message string          `json:"message"`
+	Kind    storage.BanKind `json:"kind"`
+	Reason  string          `json:"reason,omitempty"`
+	Until   time.Time       `json:"until,omitzero"`
+}
+
+func writeBanned(w http.ResponseWriter, r *http.Request, ban storage.Ban) {
+	e := apiError{
+		Status:  http.StatusForbidden,
+		Message: "account banned",
+		Extra:   map[string]any{"kind": ban.Kind},
+	}
+	if ban.Reason != "" {
+		e.Extra["reason"] = ban.Reason
+	}
+	if ban.Kind == storage.BanSuspended {
+		e.Message = "account suspended"
+		e.Extra["until"] = ban.Until
+	}
+
+	writeAPIError(w, r, e)
+}
+
+// withActive refuses requests of suspended users. It has to be wrapped
+// by withAuth, which already refuses banned users.
+func withActive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ban, _ := r.Context().Value(BAN).(storage.Ban)
+		if kind := ban.Effective(time.Now()); kind == storage.BanSuspended || kind == storage.BanPermanent {
+			writeBanned(w, r, ban)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type BanRequest struct {
+	Kind   storage.BanKind `json:"kind"`
+	Reason string          `json:"reason"`
+	// Until is required for suspensions.
+	Until time.Time `json:"until"`
+}
+
+// handleBanUser bans, suspends or shadowbans a user. A banned user is
+// logged out of all sessions, a suspended one can keep reading.
+func (h *AdminHandler) handleBanUser(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	if !authz.Can(actor, authz.ManageUsers, authz.Resource{}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	var req BanRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDecodeError(w, r, err, "wrong request body, kind expected")
+		return
+	}
+	if req.Kind == storage.BanNone {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "kind",
+			Message:  "required",
+		}})
+		return
+	}
+
+	username := r.PathValue("username")
+	target, err := h.store(r).FindUser(username)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeError(w, r, http.StatusNotFound, "user not found")
+		return
+	}
+	if err == nil && target.Role == authz.RoleAdmin {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "path",
+			Param:    "username",
+			Value:    username,
+			Message:  "admins can not be banned",
+		}})
+		return
+	}
+
+	user, err := h.store(r).SetUserBan(username, storage.Ban{
+		Kind:      req.Kind,
+		Reason:    req.Reason,
+		Until:     req.Until,
+		Moderator: storage.PostAuthor{Name: actor.Name, ID: actor.ID},
+	})
+	if errors.Is(err, storage.ErrUnknownBan) || errors.Is(err, storage.ErrInvalidUntil) {
+		param, value := "kind", string(req.Kind)
+		if errors.Is(err, storage.ErrInvalidUntil) {
+			param, value = "until", req.Until.Format(time.RFC3339)
+		}
+
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    param,
+			Value:    value,
+			Message:  err.Error(),
+		}})
+		return
+	}
+	if err == nil && req.Kind == storage.BanPermanent {
+		err = h.store(r).DeleteUserSessions(user.ID)
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("ban user: %w", err))
+		return
+	}
+
+	details := string(req.Kind)
+	if req.Kind == storage.BanSuspended {
+		details += " until " + req.Until.Format(time.RFC3339)
+	}
+	if req.Reason != "" {
+		details += ": " + req.Reason
+	}
+	audit(r, h.Storage, actor, authz.ManageUsers, "", username, details)
+
+	writeJSON(w, r, http.StatusOK, user.Ban)
+}
+
+func (h *AdminHandler) handleUnbanUser(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	if !authz.Can(actor, authz.ManageUsers, authz.Resource{}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	username := r.PathValue("username")
+	user, err := h.store(r).SetUserBan(username, storage.Ban{})
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeError(w, r, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("lift ban: %w", err))
+		return
+	}
+
+	audit(r, h.Storage, actor, authz.ManageUsers, "", username, "unban")
+
+	writeJSON(w, r, http.StatusOK, user.Ban)
+}
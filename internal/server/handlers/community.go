@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"redditclone/internal/authz"
+	"redditclone/internal/storage"
+	"regexp"
+)
+
+type CommunityHandler struct {
+	Storage storage.Storage
+}
+
+type NewCommunityRequest struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Rules       string             `json:"rules"`
+	PostTypes   []storage.PostType `json:"postTypes"`
+	Restricted  bool               `json:"restricted"`
+}
+
+var communityNameRe = regexp.MustCompile(`^[a-z0-9_]{3,21}$`)
+
+func NewCommunityHandler(storage storage.Storage) *CommunityHandler {
+	return &CommunityHandler{Storage: storage}
+}
+
+func (h *CommunityHandler) handleNewCommunity(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(USER).(UserClaims)
+
+	var req NewCommunityRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDecodeError(w, r, err, "wrong request body, community expected")
+		return
+	}
+
+	if !communityNameRe.MatchString(req.Name) {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "name",
+			Value:    req.Name,
+			Message:  "must be 3 to 21 lowercase letters, digits or underscores",
+		}})
+		return
+	}
+	for _, t := range req.PostTypes {
+		if t != storage.TEXT && t != storage.LINK {
+			jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+				Location: "body",
+				Param:    "postTypes",
+				Value:    string(t),
+				Message:  "unknown post type",
+			}})
+			return
+		}
+	}
+
+	community, err := h.store(r).AddCommunity(storage.Community{
+		Name:        req.Name,
+		Description: req.Description,
+		Rules:       req.Rules,
+		Creator:     storage.PostAuthor{Name: user.Name, ID: user.ID},
+		PostTypes:   req.PostTypes,
+		Restricted:  req.Restricted,
+	})
+	if errors.Is(err, storage.ErrCommunityAlreadyExists) {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "name",
+			Value:    req.Name,
+			Message:  "already exists",
+		}})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("save community: %w", err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, community)
+}
+
+func (h *CommunityHandler) handleGetCommunities(w http.ResponseWriter, r *http.Request) {
+	communities := h.store(r).GetCommunities()
+
+	writeJSON(w, r, http.StatusOK, communities)
+}
+
+func (h *CommunityHandler) handleGetCommunity(w http.ResponseWriter, r *http.Request) {
+	community, err := h.store(r).GetCommunity(r.PathValue("name"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "community not found")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, community)
+}
+
+type ModeratorRequest struct {
+	UserName string `json:"username"`
+}
+
+// handleAddModerator appoints a moderator. Only the creator of the community
+// and admins can manage moderators, and every change is audited.
+func (h *CommunityHandler) handleAddModerator(w http.ResponseWriter, r *http.Request) {
+	community, actor, ok := h.moderatedCommunity(w, r)
+	if !ok {
+		return
+	}
+
+	var req ModeratorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDecodeError(w, r, err, "wrong request body, username expected")
+		return
+	}
+
+	user, err := h.store(r).FindUser(req.UserName)
+	if err != nil {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "username",
+			Value:    req.UserName,
+			Message:  "user not found",
+		}})
+		return
+	}
+
+	community, err = h.store(r).AddModerator(community.Name, storage.PostAuthor{Name: user.Name, ID: user.ID})
+	h.writeModerators(w, r, actor, community, "add "+user.Name, err)
+}
+
+func (h *CommunityHandler) handleRemoveModerator(w http.ResponseWriter, r *http.Request) {
+	community, actor, ok := h.moderatedCommunity(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.store(r).FindUser(r.PathValue("username"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "user not found")
+		return
+	}
+
+	community, err = h.store(r).RemoveModerator(community.Name, user.ID)
+	h.writeModerators(w, r, actor, community, "remove "+user.Name, err)
+}
+
+// moderatedCommunity loads the community of the request and checks that
+// the user can manage its moderators.
+func (h *CommunityHandler) moderatedCommunity(w http.ResponseWriter, r *http.Request) (storage.Community, authz.Subject, bool) {
+	community, err := h.store(r).GetCommunity(r.PathValue("name"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "community not found")
+		return storage.Community{}, authz.Subject{}, false
+	}
+
+	actor := subject(r, h.Storage)
+	resource := authz.Resource{AuthorID: community.Creator.ID, Community: community.Name}
+	if !authz.Can(actor, authz.ManageModerators, resource) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return storage.Community{}, authz.Subject{}, false
+	}
+
+	return community, actor, true
+}
+
+func (h *CommunityHandler) writeModerators(w http.ResponseWriter, r *http.Request, actor authz.Subject, community storage.Community, details string, err error) {
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("save community: %w", err))
+		return
+	}
+
+	audit(r, h.Storage, actor, authz.ManageModerators, community.Name, community.Name, details)
+
+	writeJSON(w, r, http.StatusOK, community)
+}
@@ -1,8 +1,17 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"redditclone/internal/ratelimit"
+	"redditclone/internal/storage"
+	"redditclone/internal/validate"
+	"strings"
+	"time"
 )
 
 type RequestError struct {
@@ -12,10 +21,235 @@ type RequestError struct {
 	Message  string `json:"msg"`
 }
 
-func jsonError(w http.ResponseWriter, code int, errs []RequestError) {
-	resp, _ := json.Marshal(map[string]any{
-		"errors": errs,
+// PROBLEM is set in the context of requests whose errors are written as
+// RFC 7807 problem documents.
+const PROBLEM key = "problem"
+
+// ProblemContentType is the media type of RFC 7807 problem documents.
+const ProblemContentType = "application/problem+json"
+
+// apiError is an error response. It is written as {"message": ...} or,
+// with rejected fields, as {"errors": [...]}. Problem documents carry the
+// message as detail and the fields as the errors member.
+type apiError struct {
+	Status  int
+	Message string
+	Fields  []RequestError
+	// Extra members are added to the body, e.g. the details of a ban.
+	Extra map[string]any
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, e apiError) {
+	body := map[string]any{}
+	for name, value := range e.Extra {
+		body[name] = value
+	}
+
+	contentType := "application/json"
+	if problem, _ := r.Context().Value(PROBLEM).(bool); problem {
+		contentType = ProblemContentType
+		body["type"] = "about:blank"
+		body["title"] = http.StatusText(e.Status)
+		body["status"] = e.Status
+		if e.Message != "" {
+			body["detail"] = e.Message
+		}
+		if len(e.Fields) != 0 {
+			body["errors"] = e.Fields
+		}
+	} else if len(e.Fields) != 0 {
+		body["errors"] = e.Fields
+	} else {
+		body["message"] = e.Message
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error with a message.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeAPIError(w, r, apiError{Status: status, Message: message})
+}
+
+// jsonError writes an error with the rejected fields of the request.
+func jsonError(w http.ResponseWriter, r *http.Request, status int, errs []RequestError) {
+	writeAPIError(w, r, apiError{Status: status, Fields: errs})
+}
+
+// writeInternalError logs err and reports a 500 without its details.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	requestLogger(r).Error("internal error", "err", err)
+	writeError(w, r, http.StatusInternalServerError, "internal server error")
+}
+
+// unmatchedPattern is the catch-all of a mux whose requests no route
+// matches.
+const unmatchedPattern = "/{path...}"
+
+// unmatchedMethods are tried to find the methods allowed for a path.
+var unmatchedMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// unmatched answers requests no route of mux matches, with 405 and the
+// allowed methods if the path has routes for other methods and with 404
+// otherwise. Unlike the errors of the mux itself, they are JSON.
+func unmatched(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range unmatchedMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != unmatchedPattern {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, r, http.StatusNotFound, "not found")
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// errorStatuses are the statuses of storage errors.
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	{storage.ErrPostNotFound, http.StatusNotFound},
+	{storage.ErrCommentNotFound, http.StatusNotFound},
+	{storage.ErrUserNotFound, http.StatusNotFound},
+	{storage.ErrCommunityNotFound, http.StatusNotFound},
+	{storage.ErrPermissionDenied, http.StatusForbidden},
+	{storage.ErrPostingRestricted, http.StatusForbidden},
+	{storage.ErrUserAlreadyExists, http.StatusConflict},
+	{storage.ErrCommunityAlreadyExists, http.StatusConflict},
+	{storage.ErrCommentDeleted, http.StatusUnprocessableEntity},
+	{storage.ErrTitleEditExpired, http.StatusUnprocessableEntity},
+	{storage.ErrNotTextPost, http.StatusUnprocessableEntity},
+	{storage.ErrPostTypeNotAllowed, http.StatusUnprocessableEntity},
+	{storage.ErrUnknownBan, http.StatusUnprocessableEntity},
+	{storage.ErrInvalidUntil, http.StatusUnprocessableEntity},
+	{storage.ErrUnknownDecision, http.StatusUnprocessableEntity},
+	{storage.ErrUnknownSort, http.StatusBadRequest},
+	{storage.ErrUnknownWindow, http.StatusBadRequest},
+	{storage.ErrInvalidCursor, http.StatusBadRequest},
+	{storage.ErrSessionNotFound, http.StatusUnauthorized},
+	{storage.ErrRefreshTokenReused, http.StatusUnauthorized},
+	{storage.ErrInvalidPassword, http.StatusUnauthorized},
+}
+
+// writeStorageError writes the storage error wrapped by err with its
+// status. Other errors are internal errors.
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			writeError(w, r, e.status, e.err.Error())
+			return
+		}
+	}
+
+	writeInternalError(w, r, err)
+}
+
+// writeTooManyRequests refuses a request with 429 and tells the client
+// when to retry.
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, message string) {
+	seconds := ratelimit.SetRetryAfter(w.Header(), retryAfter)
+	writeAPIError(w, r, apiError{
+		Status:  http.StatusTooManyRequests,
+		Message: message,
+		Extra:   map[string]any{"retryAfter": seconds},
 	})
+}
+
+// writeJSON writes v as the response body. It is encoded up front, so an
+// encoding failure can still be reported as an error.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	_, span := tracer.Start(r.Context(), "encode response")
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(v)
+	span.End()
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("encode response: %w", err))
+		return
+	}
 
-	http.Error(w, string(resp), code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// writeSuccess confirms a request that has nothing else to return.
+func writeSuccess(w http.ResponseWriter, r *http.Request, status int) {
+	writeJSON(w, r, status, map[string]string{"message": "success"})
+}
+
+// bodyErrors reports rejected fields of the request body.
+func bodyErrors(errs []validate.Error) []RequestError {
+	reqErrs := make([]RequestError, 0, len(errs))
+	for _, err := range errs {
+		reqErrs = append(reqErrs, RequestError{
+			Location: "body",
+			Param:    err.Param,
+			Value:    err.Value,
+			Message:  err.Message,
+		})
+	}
+	return reqErrs
+}
+
+// writeDecodeError reports a request body that could not be decoded.
+// Bodies over the size limit get 413 Request Entity Too Large.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		jsonError(w, r, http.StatusRequestEntityTooLarge, []RequestError{{
+			Location: "body",
+			Message:  fmt.Sprintf("must be at most %d bytes", tooLarge.Limit),
+		}})
+		return
+	}
+
+	jsonError(w, r, http.StatusBadRequest, []RequestError{{
+		Location: "body",
+		Message:  message,
+	}})
+}
+
+// limitBody caps the size of request bodies, reading past the limit fails
+// with *http.MaxBytesError.
+func limitBody(maxBytes int64, next http.Handler) http.Handler {
+	if maxBytes <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withErrorFormat makes API responses JSON by default and selects problem
+// documents for errors when they are always on or the client accepts them.
+func withErrorFormat(problemDetails bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if problemDetails || strings.Contains(r.Header.Get("Accept"), ProblemContentType) {
+			r = r.WithContext(context.WithValue(r.Context(), PROBLEM, true))
+		}
+
+		next.ServeHTTP(w, r)
+	})
 }
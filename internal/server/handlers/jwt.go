@@ -2,51 +2,155 @@ package handlers
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"net/http"
+	"redditclone/internal/authz"
 	"redditclone/internal/storage"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type UserClaims struct {
-	ID   string `json:"id"`
-	Name string `json:"username"`
+	ID   string     `json:"id"`
+	Name string     `json:"username"`
+	Role authz.Role `json:"role,omitempty"`
 }
 
 type Claims struct {
 	User UserClaims `json:"user"`
+	// SessionID is the session the token was issued for. The token is
+	// rejected once the session ends.
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-const jwtSecret = "abc" // tmp
+// TokenPair is the response to a login or a refresh. The access token is
+// short-lived, the refresh token gets a new pair and can be used only once.
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int `json:"expiresIn"`
+}
+
+const SESSION key = "session"
+
+// Tokens issues and checks access and refresh tokens.
+type Tokens struct {
+	keys       *KeySet
+	users      storage.UserStorage
+	sessions   storage.SessionStorage
+	ttl        time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokens(keys *KeySet, users storage.UserStorage, sessions storage.SessionStorage, ttl, refreshTTL time.Duration) *Tokens {
+	return &Tokens{keys: keys, users: users, sessions: sessions, ttl: ttl, refreshTTL: refreshTTL}
+}
+
+// startSession logs the user in.
+func (t *Tokens) startSession(ctx context.Context, user storage.User) (TokenPair, error) {
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	session, err := traced(ctx, t.sessions).AddSession(user.ID, user.Name, hash, time.Now().Add(t.refreshTTL))
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return t.tokenPair(session, user.Role, refreshToken)
+}
+
+// refresh exchanges a refresh token for a new pair. Reusing a refresh token
+// means it has leaked, so the session is ended for everyone holding it.
+func (t *Tokens) refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	next, nextHash, err := newRefreshToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	sessions, users := traced(ctx, t.sessions), traced(ctx, t.users)
+
+	session, err := sessions.RefreshSession(hashToken(refreshToken), nextHash, time.Now().Add(t.refreshTTL))
+	if errors.Is(err, storage.ErrRefreshTokenReused) {
+		sessions.DeleteSession(session.ID)
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// the role may have changed since the last token
+	user, err := users.FindUser(session.UserName)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if user.Ban.Effective(time.Now()) == storage.BanPermanent {
+		sessions.DeleteSession(session.ID)
+		return TokenPair{}, storage.ErrSessionNotFound
+	}
+
+	return t.tokenPair(session, user.Role, next)
+}
+
+func (t *Tokens) tokenPair(session storage.Session, role authz.Role, refreshToken string) (TokenPair, error) {
+	token, err := t.generateJWT(session, role)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(t.ttl / time.Second),
+	}, nil
+}
+
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken is what is stored instead of a refresh token, so a leaked
+// storage file does not leak sessions.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-func generateJWT(user storage.User) (string, error) {
+func (t *Tokens) generateJWT(session storage.Session, role authz.Role) (string, error) {
 	claims := Claims{
 		User: UserClaims{
-			ID:   user.ID,
-			Name: user.Name,
+			ID:   session.UserID,
+			Name: session.UserName,
+			Role: role,
 		},
+		SessionID: session.ID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(t.ttl)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtSecret))
+	return t.keys.sign(claims)
 }
 
-func parseJWT(tokenString string) (*Claims, error) {
+func (t *Tokens) parseJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("bad sign method")
-		}
-		return []byte(jwtSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, t.keys.keyFunc)
 
 	if err != nil || !token.Valid {
 		return nil, err
@@ -55,21 +159,50 @@ func parseJWT(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
-func withAuth(next http.Handler) http.Handler {
+func (t *Tokens) withAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		inToken := ""
-		if after, ok := strings.CutPrefix(authHeader, "Bearer "); ok {
-			inToken = after
-		}
-		claims, err := parseJWT(inToken)
+		claims, user, err := t.authenticate(r)
 		if err != nil {
-			http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "unauthorized")
 			return
 		}
+		if user.Ban.Effective(time.Now()) == storage.BanPermanent {
+			writeBanned(w, r, user.Ban)
+			return
+		}
+
+		logUser(r, claims.User.ID)
 
 		ctx := context.WithValue(r.Context(), USER, claims.User)
+		ctx = context.WithValue(ctx, SESSION, claims.SessionID)
+		ctx = context.WithValue(ctx, BAN, user.Ban)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticate checks the access token of the request and loads its user.
+func (t *Tokens) authenticate(r *http.Request) (*Claims, storage.User, error) {
+	ctx, span := tracer.Start(r.Context(), "withAuth")
+	defer span.End()
+
+	authHeader := r.Header.Get("Authorization")
+	inToken := ""
+	if after, ok := strings.CutPrefix(authHeader, "Bearer "); ok {
+		inToken = after
+	}
+	claims, err := t.parseJWT(inToken)
+	if err == nil {
+		// tokens of ended sessions are revoked
+		_, err = traced(ctx, t.sessions).GetSession(claims.SessionID)
+	}
+	var user storage.User
+	if err == nil {
+		user, err = traced(ctx, t.users).FindUser(claims.User.Name)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return claims, user, err
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// Key is a key that verifies tokens and, unless it only holds a public
+// key, signs them.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey is nil for verification-only keys.
+	signKey   any
+	verifyKey any
+}
+
+// ParseKey builds a key of the algorithm from its material: the secret
+// itself for HS256, a PEM encoded private or public key for RS256 and EdDSA.
+func ParseKey(id, algorithm string, material []byte) (Key, error) {
+	key := Key{ID: id}
+
+	switch algorithm {
+	case HS256:
+		if len(material) == 0 {
+			return Key{}, fmt.Errorf("key %s: empty secret", id)
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.signKey, key.verifyKey = material, material
+		return key, nil
+	case RS256:
+		key.Method = jwt.SigningMethodRS256
+	case EdDSA:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return Key{}, fmt.Errorf("key %s: unsupported algorithm %q", id, algorithm)
+	}
+
+	block, _ := pem.Decode(material)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %s: no PEM data found", id)
+	}
+	private := strings.Contains(block.Type, "PRIVATE KEY")
+
+	var err error
+	switch {
+	case algorithm == RS256 && private:
+		var k *rsa.PrivateKey
+		k, err = jwt.ParseRSAPrivateKeyFromPEM(material)
+		if err == nil {
+			key.signKey, key.verifyKey = k, &k.PublicKey
+		}
+	case algorithm == RS256:
+		key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(material)
+	case private:
+		var k crypto.PrivateKey
+		k, err = jwt.ParseEdPrivateKeyFromPEM(material)
+		if err == nil {
+			key.signKey, key.verifyKey = k, k.(ed25519.PrivateKey).Public()
+		}
+	default:
+		key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(material)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	return key, nil
+}
+
+// KeySet holds all keys tokens are accepted from, selected by the kid
+// header. New tokens are signed by one of them, so keys can be rotated
+// by adding a new signing key and removing the old one once its tokens
+// have expired.
+type KeySet struct {
+	keys    map[string]Key
+	signing Key
+}
+
+func NewKeySet(keys []Key, signingKeyID string) (*KeySet, error) {
+	set := &KeySet{keys: map[string]Key{}}
+	for _, k := range keys {
+		if _, ok := set.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		set.keys[k.ID] = k
+	}
+
+	signing, ok := set.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKeyID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+	set.signing = signing
+
+	return set, nil
+}
+
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.signKey)
+}
+
+// keyFunc finds the verification key of a token. Tokens without kid are
+// checked against the signing key. The algorithm of the token has to
+// match the one of the key.
+func (s *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	key := s.signing
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok = s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("bad sign method")
+	}
+
+	return key.verifyKey, nil
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// handleJWKS publishes the public keys of the set as a JSON Web Key Set.
+// HMAC secrets are never published.
+func (s *KeySet) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []jwk{}
+	for _, k := range s.keys {
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, jwk{
+				KeyType:   "RSA",
+				ID:        k.ID,
+				Algorithm: RS256,
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, jwk{
+				KeyType:   "OKP",
+				ID:        k.ID,
+				Algorithm: EdDSA,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]any{"keys": keys})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+	"redditclone/internal/metrics"
+	"time"
+)
+
+// withMetrics records every request with the route pattern that matched
+// it. Requests no pattern matches share the route "unmatched", so scanners
+// can not blow up the number of series.
+func withMetrics(m *metrics.Metrics, route func(r *http.Request) string, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		method, pattern := r.Method, route(r)
+		if pattern == "" {
+			method, pattern = "", "unmatched"
+		}
+		m.ObserveRequest(method, pattern, rec.status, time.Since(start))
+	})
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"redditclone/internal/authz"
+	"redditclone/internal/storage"
+	"slices"
+	"unicode/utf8"
+)
+
+// MaxReportReason is the maximum length of a report reason in characters.
+const MaxReportReason = 500
+
+type ModerationHandler struct {
+	Storage storage.Storage
+}
+
+type ReportRequest struct {
+	Reason string `json:"reason"`
+}
+
+// decisions maps the actions of the moderation queue to decisions.
+var decisions = map[string]storage.ModStatus{
+	"approve": storage.ModApproved,
+	"remove":  storage.ModRemoved,
+	"dismiss": storage.ModDismissed,
+}
+
+func NewModerationHandler(storage storage.Storage) *ModerationHandler {
+	return &ModerationHandler{Storage: storage}
+}
+
+func (h *ModerationHandler) handleReportPost(w http.ResponseWriter, r *http.Request) {
+	report, ok := parseReport(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := h.store(r).ReportPost(r.PathValue("id"), report)
+	writeReportResult(w, r, err)
+}
+
+func (h *ModerationHandler) handleReportComment(w http.ResponseWriter, r *http.Request) {
+	report, ok := parseReport(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := h.store(r).ReportComment(r.PathValue("postID"), r.PathValue("commentID"), report)
+	writeReportResult(w, r, err)
+}
+
+func parseReport(w http.ResponseWriter, r *http.Request) (storage.Report, bool) {
+	user := r.Context().Value(USER).(UserClaims)
+
+	var req ReportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDecodeError(w, r, err, "wrong request body, reason expected")
+		return storage.Report{}, false
+	}
+
+	if req.Reason == "" || utf8.RuneCountInString(req.Reason) > MaxReportReason {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "reason",
+			Value:    req.Reason,
+			Message:  "must be 1 to 500 characters long",
+		}})
+		return storage.Report{}, false
+	}
+
+	return storage.Report{UserID: user.ID, Reason: req.Reason}, true
+}
+
+func writeReportResult(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		writeStorageError(w, r, fmt.Errorf("save report: %w", err))
+		return
+	}
+
+	writeSuccess(w, r, http.StatusCreated)
+}
+
+// handleGetModQueue lists the items of the communities the user moderates,
+// all communities for admins. The status parameter selects kept or removed
+// items instead of open ones, community narrows the queue down.
+func (h *ModerationHandler) handleGetModQueue(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	query := storage.ModQueueQuery{
+		Status: storage.ModStatus(r.URL.Query().Get("status")),
+	}
+
+	switch query.Status {
+	case storage.ModNone, storage.ModOpen, storage.ModApproved, storage.ModRemoved, storage.ModDismissed:
+	default:
+		jsonError(w, r, http.StatusBadRequest, []RequestError{{
+			Location: "query",
+			Param:    "status",
+			Value:    string(query.Status),
+			Message:  "unknown status",
+		}})
+		return
+	}
+
+	if actor.Role != authz.RoleAdmin {
+		query.Communities = actor.Moderates
+		if len(query.Communities) == 0 {
+			writeError(w, r, http.StatusForbidden, "permission denied")
+			return
+		}
+	}
+	if community := r.URL.Query().Get("community"); community != "" {
+		if !authz.Can(actor, authz.Moderate, authz.Resource{Community: community}) {
+			writeError(w, r, http.StatusForbidden, "permission denied")
+			return
+		}
+		query.Communities = []string{community}
+	}
+
+	items := h.store(r).GetModQueue(query)
+	writeJSON(w, r, http.StatusOK, items)
+}
+
+// handleModerate applies the action of the path to a post or, if the path
+// has a comment id, a comment. Every decision is audited.
+func (h *ModerationHandler) handleModerate(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	postID, commentID := r.PathValue("postID"), r.PathValue("commentID")
+
+	decision, ok := decisions[r.PathValue("action")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "unknown action, expected approve, remove or dismiss")
+		return
+	}
+
+	post, err := h.store(r).GetPost(postID)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "post not found")
+		return
+	}
+	if !authz.Can(actor, authz.Moderate, authz.Resource{AuthorID: post.Author.ID, Community: post.Category}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	moderator := storage.PostAuthor{Name: actor.Name, ID: actor.ID}
+	target := postID
+	if commentID == "" {
+		post, err = h.store(r).ModeratePost(postID, decision, moderator)
+	} else {
+		target = commentID
+		post, err = h.store(r).ModerateComment(postID, commentID, decision, moderator)
+	}
+	if err != nil {
+		writeStorageError(w, r, fmt.Errorf("save decision: %w", err))
+		return
+	}
+
+	audit(r, h.Storage, actor, authz.Moderate, post.Category, target, string(decision))
+
+	moderation := post.Moderation
+	if i := slices.IndexFunc(post.Comments, func(c storage.Comment) bool { return c.ID == commentID }); i != -1 {
+		moderation = post.Comments[i].Moderation
+	}
+	writeJSON(w, r, http.StatusOK, moderation)
+}
@@ -5,175 +5,473 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"redditclone/internal/authz"
+	"redditclone/internal/metrics"
 	"redditclone/internal/storage"
-	"sort"
-	"time"
+	"redditclone/internal/validate"
+	"redditclone/internal/views"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type PostHandler struct {
 	Storage storage.Storage
+	Views   *views.Counter
+	Tokens  *Tokens
+	Metrics *metrics.Metrics
 }
 
 type key string
 
 const USER key = "user"
 
-func NewPostHandler(storage storage.Storage) PostHandler {
+func NewPostHandler(storage storage.Storage, views *views.Counter, tokens *Tokens, metrics *metrics.Metrics) PostHandler {
 	return PostHandler{
 		Storage: storage,
+		Views:   views,
+		Tokens:  tokens,
+		Metrics: metrics,
 	}
 }
 
+// NewPostRequest is the body of new post requests. Text is the content of
+// text posts, URL of link posts.
+type NewPostRequest struct {
+	Type     storage.PostType `json:"type"`
+	Category string           `json:"category"`
+	Title    string           `json:"title"`
+	Text     string           `json:"text"`
+	URL      string           `json:"url"`
+}
+
 func (h *PostHandler) handleNewPost(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value(USER).(UserClaims)
 
-	rawPost := &storage.RawPost{}
-	err := json.NewDecoder(r.Body).Decode(rawPost)
+	var req NewPostRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, []RequestError{{
-			Location: "post",
-			Message:  err.Error(),
+		writeDecodeError(w, r, err, "wrong request body, post expected")
+		return
+	}
+
+	rawPost := &storage.RawPost{Type: req.Type, Category: req.Category, Title: req.Title, Content: req.Text}
+	if req.Type == storage.LINK {
+		rawPost.Content = strings.TrimSpace(req.URL)
+	}
+	if errs := validate.Post(rawPost.Type, rawPost.Title, rawPost.Content); len(errs) != 0 {
+		jsonError(w, r, http.StatusUnprocessableEntity, bodyErrors(errs))
+		return
+	}
+
+	community, err := h.store(r).GetCommunity(rawPost.Category)
+	if err != nil {
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
+			Location: "body",
+			Param:    "category",
+			Value:    rawPost.Category,
+			Message:  "unknown category",
 		}})
 		return
 	}
 
-	post := h.Storage.AddPost(*rawPost, user.Name, user.ID)
+	err = community.CanPost(user.ID, rawPost.Type)
+	if err != nil {
+		statusCode := http.StatusUnprocessableEntity
+		if errors.Is(err, storage.ErrPostingRestricted) {
+			statusCode = http.StatusForbidden
+		}
+
+		jsonError(w, r, statusCode, []RequestError{{
+			Location: "body",
+			Param:    "category",
+			Value:    rawPost.Category,
+			Message:  err.Error(),
+		}})
+		return
+	}
 
-	w.WriteHeader(http.StatusCreated)
-	err = json.NewEncoder(w).Encode(&post)
+	post, err := h.store(r).AddPost(*rawPost, user.Name, user.ID)
 	if err != nil {
-		http.Error(w, "could not encode response", http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("save post: %w", err))
+		return
 	}
+	h.Metrics.PostCreated()
+
+	h.writePost(w, r, http.StatusCreated, post, h.hiddenAuthors(r))
 }
 
 func (h *PostHandler) handleDeletePost(w http.ResponseWriter, r *http.Request) {
-	user := r.Context().Value(USER).(UserClaims)
+	actor := subject(r, h.Storage)
 	postID := r.PathValue("id")
 
-	err := h.Storage.DeletePost(postID, user.ID)
+	post, err := h.store(r).GetPost(postID)
+	if err == nil {
+		err = h.store(r).DeletePost(postID, actor)
+	}
 	if err != nil {
-		var statusCode int
-		if errors.Is(err, storage.ErrPostNotFound) {
-			statusCode = http.StatusBadRequest
-		} else if errors.Is(err, storage.ErrPermissionDenied) {
-			statusCode = http.StatusForbidden
-		}
-
-		http.Error(w, fmt.Sprintf("{\"message\":\"%s\"}", err.Error()), statusCode)
+		writeStorageError(w, r, fmt.Errorf("delete post: %w", err))
 		return
 	}
+	h.auditContent(r, actor, authz.DeletePost, post.Author, post.Category, postID)
 
-	w.Write([]byte(`{"message":"success"}`))
+	writeSuccess(w, r, http.StatusOK)
 }
 
-func (h *PostHandler) handleGetPosts(w http.ResponseWriter, r *http.Request) {
-	posts := h.Storage.GetPosts()
+// auditContent records an action on a post or a comment when the actor
+// could only perform it as a moderator or an admin.
+func (h *PostHandler) auditContent(r *http.Request, actor authz.Subject, action authz.Action, author storage.PostAuthor, community, target string) {
+	if authz.Privileged(actor, action, authz.Resource{AuthorID: author.ID, Community: community}) {
+		audit(r, h.Storage, actor, action, community, target, "author "+author.Name)
+	}
+}
+
+// PostEdit is the body of post edit requests, missing fields are not changed.
+type PostEdit struct {
+	Title *string `json:"title"`
+	Text  *string `json:"text"`
+}
+
+func (h *PostHandler) handleEditPost(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	postID := r.PathValue("id")
+
+	var edit PostEdit
+	err := json.NewDecoder(r.Body).Decode(&edit)
+	if err != nil {
+		writeDecodeError(w, r, err, "wrong request body, title or text expected")
+		return
+	}
+
+	var errs []validate.Error
+	if edit.Title != nil {
+		if err := validate.Title(*edit.Title); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+	if edit.Text != nil {
+		if err := validate.Text(*edit.Text); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+	if len(errs) != 0 {
+		jsonError(w, r, http.StatusUnprocessableEntity, bodyErrors(errs))
+		return
+	}
 
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].Score < posts[j].Score
+	post, err := h.store(r).EditPost(postID, actor, storage.PostEdit{
+		Title: edit.Title,
+		Text:  edit.Text,
 	})
+	if err != nil {
+		writeStorageError(w, r, fmt.Errorf("edit post: %w", err))
+		return
+	}
 
-	err := json.NewEncoder(w).Encode(&posts)
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
+}
+
+// handleGetPostRevisions returns the previous versions of a post, oldest first.
+// Only the author and moderators can see them.
+func (h *PostHandler) handleGetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+
+	post, err := h.store(r).GetPost(r.PathValue("id"))
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode posts",
-		}})
+		writeStorageError(w, r, err)
+		return
+	}
+	if !authz.Can(actor, authz.ViewRevisions, authz.Resource{AuthorID: post.Author.ID, Community: post.Category}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
 	}
+	h.auditContent(r, actor, authz.ViewRevisions, post.Author, post.Category, post.ID)
+
+	writeRevisions(w, r, post.Revisions)
 }
 
-func (h *PostHandler) handleGetCategoryPosts(w http.ResponseWriter, r *http.Request) {
-	category := (r.PathValue("category"))
+func (h *PostHandler) handleGetCommentRevisions(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	postID, commentID := r.PathValue("postID"), r.PathValue("commentID")
 
-	posts := []storage.Post{}
-	for _, p := range h.Storage.GetPosts() {
-		if p.Category == category {
-			posts = append(posts, p)
-		}
+	post, err := h.store(r).GetPost(postID)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
 	}
 
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].Score < posts[j].Score
+	i := slices.IndexFunc(post.Comments, func(c storage.Comment) bool {
+		return c.ID == commentID
 	})
+	if i == -1 {
+		writeStorageError(w, r, storage.ErrCommentNotFound)
+		return
+	}
+	comment := post.Comments[i]
+	if !authz.Can(actor, authz.ViewRevisions, authz.Resource{AuthorID: comment.Author.ID, Community: post.Category}) {
+		writeError(w, r, http.StatusForbidden, "permission denied")
+		return
+	}
+	h.auditContent(r, actor, authz.ViewRevisions, comment.Author, post.Category, comment.ID)
+
+	writeRevisions(w, r, comment.Revisions)
+}
 
-	err := json.NewEncoder(w).Encode(&posts)
+func writeRevisions(w http.ResponseWriter, r *http.Request, revisions []storage.Revision) {
+	if revisions == nil {
+		revisions = []storage.Revision{}
+	}
+
+	writeJSON(w, r, http.StatusOK, revisions)
+}
+
+func (h *PostHandler) handleGetPosts(w http.ResponseWriter, r *http.Request) {
+	query, errs := parsePostQuery(r)
+	if len(errs) != 0 {
+		jsonError(w, r, http.StatusBadRequest, errs)
+		return
+	}
+
+	h.writePostPage(w, r, query)
+}
+
+func (h *PostHandler) handleGetCategoryPosts(w http.ResponseWriter, r *http.Request) {
+	query, errs := parsePostQuery(r)
+	if len(errs) != 0 {
+		jsonError(w, r, http.StatusBadRequest, errs)
+		return
+	}
+	query.Category = r.PathValue("category")
+
+	_, err := h.store(r).GetCommunity(query.Category)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode posts",
-		}})
+		writeError(w, r, http.StatusNotFound, "community not found")
+		return
 	}
+
+	h.writePostPage(w, r, query)
 }
 
-func (h *PostHandler) handleGetUserPosts(w http.ResponseWriter, r *http.Request) {
-	username := r.PathValue("username")
+// parsePostQuery reads the limit, after, before, sort and t (time window)
+// listing parameters.
+func parsePostQuery(r *http.Request) (storage.PostQuery, []RequestError) {
+	params := r.URL.Query()
+	query := storage.PostQuery{
+		Sort:   storage.PostSort(params.Get("sort")),
+		Window: storage.TimeWindow(params.Get("t")),
+		After:  params.Get("after"),
+		Before: params.Get("before"),
+	}
 
-	posts := []storage.Post{}
-	for _, p := range h.Storage.GetPosts() {
-		if p.Author.Name == username {
-			posts = append(posts, p)
+	var errs []RequestError
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > storage.MaxPostLimit {
+			errs = append(errs, RequestError{
+				Location: "query",
+				Param:    "limit",
+				Value:    limit,
+				Message:  fmt.Sprintf("must be a number from 1 to %d", storage.MaxPostLimit),
+			})
 		}
+		query.Limit = n
+	}
+	if query.After != "" && query.Before != "" {
+		errs = append(errs, RequestError{
+			Location: "query",
+			Param:    "before",
+			Value:    query.Before,
+			Message:  "after and before can not be used together",
+		})
 	}
 
-	sort.Slice(posts, func(i, j int) bool {
-		iTime, _ := time.Parse(time.RFC3339, posts[i].CreatedTime)
-		jTime, _ := time.Parse(time.RFC3339, posts[j].CreatedTime)
-		return iTime.Before(jTime)
-	})
+	return query, errs
+}
 
-	err := json.NewEncoder(w).Encode(&posts)
+// writePostPage writes a page of posts as a JSON array. Cursors of the
+// neighbouring pages are sent in the Link header.
+func (h *PostHandler) writePostPage(w http.ResponseWriter, r *http.Request, query storage.PostQuery) {
+	query.HiddenAuthors = h.hiddenAuthors(r)
+	page, err := h.store(r).ListPosts(query)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode posts",
+		param, value := "sort", string(query.Sort)
+		switch {
+		case errors.Is(err, storage.ErrUnknownWindow):
+			param, value = "t", string(query.Window)
+		case errors.Is(err, storage.ErrInvalidCursor) && query.Before != "":
+			param, value = "before", query.Before
+		case errors.Is(err, storage.ErrInvalidCursor):
+			param, value = "after", query.After
+		}
+
+		jsonError(w, r, http.StatusBadRequest, []RequestError{{
+			Location: "query",
+			Param:    param,
+			Value:    value,
+			Message:  err.Error(),
 		}})
+		return
+	}
+
+	var links []string
+	if page.After != "" {
+		links = append(links, pageLink(r, "after", page.After, "next"))
+	}
+	if page.Before != "" {
+		links = append(links, pageLink(r, "before", page.Before, "prev"))
 	}
+	if len(links) != 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	for i, post := range page.Posts {
+		page.Posts[i] = storage.HideAuthors(post, query.HiddenAuthors)
+	}
+
+	writeJSON(w, r, http.StatusOK, page.Posts)
+}
+
+// pageLink builds a Link header entry relative to the requested URL,
+// so it stays correct behind the /api prefix.
+func pageLink(r *http.Request, param, cursor, rel string) string {
+	params := r.URL.Query()
+	params.Del("after")
+	params.Del("before")
+	params.Set(param, cursor)
+
+	return fmt.Sprintf(`<?%s>; rel="%s"`, params.Encode(), rel)
+}
+
+// handleGetUserPosts lists the posts of a user, newest first unless the
+// sort parameter says otherwise.
+func (h *PostHandler) handleGetUserPosts(w http.ResponseWriter, r *http.Request) {
+	query, errs := parsePostQuery(r)
+	if len(errs) != 0 {
+		jsonError(w, r, http.StatusBadRequest, errs)
+		return
+	}
+	query.Author = r.PathValue("username")
+	if query.Sort == "" {
+		query.Sort = storage.SortNew
+	}
+
+	h.writePostPage(w, r, query)
 }
 
 func (h *PostHandler) handleGetPostDetails(w http.ResponseWriter, r *http.Request) {
 	postID := r.PathValue("id")
 
-	post, err := h.Storage.GetPost(postID)
+	viewer, _ := h.viewer(r)
+	hidden := h.hiddenFrom(r, viewer)
+
+	// removed posts are only shown in the moderation queue
+	post, err := h.store(r).GetPost(postID)
+	if err == nil && (post.Moderation.Removed() || slices.Contains(hidden, post.Author.Name)) {
+		err = storage.ErrPostNotFound
+	}
 	if err != nil {
-		http.Error(w, `{"message":"invalid post id"}`, http.StatusBadRequest)
+		writeStorageError(w, r, err)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(&post)
-	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode post",
-		}})
+	h.Views.View(post.ID, viewerID(r, viewer))
+	post.Views += h.Views.Pending(post.ID)
+
+	h.writePost(w, r, http.StatusOK, post, hidden)
+}
+
+// viewer returns the logged in user of a request, the token is optional
+// outside of withAuth.
+func (h *PostHandler) viewer(r *http.Request) (UserClaims, bool) {
+	if user, ok := r.Context().Value(USER).(UserClaims); ok {
+		return user, true
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims, err := h.Tokens.parseJWT(token)
+		if err == nil {
+			return claims.User, true
+		}
 	}
+	return UserClaims{}, false
+}
+
+// viewerID identifies the viewer of a post: a logged in user by ID,
+// anyone else by IP address.
+func viewerID(r *http.Request, viewer UserClaims) string {
+	if viewer.ID != "" {
+		return "user:" + viewer.ID
+	}
+	return "ip:" + clientIP(r)
+}
+
+// hiddenAuthors returns the shadowbanned users whose posts and comments
+// the viewer of the request must not see.
+func (h *PostHandler) hiddenAuthors(r *http.Request) []string {
+	viewer, _ := h.viewer(r)
+	return h.hiddenFrom(r, viewer)
+}
+
+// hiddenFrom returns the shadowbanned users whose posts and comments the
+// viewer must not see, that is all of them but the viewer.
+func (h *PostHandler) hiddenFrom(r *http.Request, viewer UserClaims) []string {
+	return slices.DeleteFunc(h.store(r).ShadowbannedUsers(), func(name string) bool {
+		return name == viewer.Name
+	})
+}
+
+// writePost writes the post as the viewer sees it, without the comments
+// of the hidden authors.
+func (h *PostHandler) writePost(w http.ResponseWriter, r *http.Request, status int, post storage.Post, hidden []string) {
+	post = storage.HideAuthors(post, hidden)
+
+	writeJSON(w, r, status, post)
 }
 
 func (h *PostHandler) handleUpvote(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, h.Storage.UpvotePost)
+	h.handleVote(w, r, metrics.Up, h.store(r).UpvotePost)
 }
 func (h *PostHandler) handleDownvote(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, h.Storage.DownvotePost)
+	h.handleVote(w, r, metrics.Down, h.store(r).DownvotePost)
 }
 func (h *PostHandler) handleUnvote(w http.ResponseWriter, r *http.Request) {
-	handleVote(w, r, h.Storage.UnvotePost)
+	h.handleVote(w, r, metrics.None, h.store(r).UnvotePost)
 }
 
-func handleVote(w http.ResponseWriter, r *http.Request, voteFunc func(id, userID string) (storage.Post, error)) {
+func (h *PostHandler) handleVote(w http.ResponseWriter, r *http.Request, direction string, voteFunc func(id, userID string) (storage.Post, error)) {
 	user := r.Context().Value(USER).(UserClaims)
 
 	post, err := voteFunc(r.PathValue("id"), user.ID)
 	if err != nil {
-		http.Error(w, `{"message":"invalid post id"}`, http.StatusBadRequest)
+		writeStorageError(w, r, fmt.Errorf("vote: %w", err))
 		return
 	}
+	h.Metrics.Voted(direction)
+
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
+}
+
+func (h *PostHandler) handleCommentUpvote(w http.ResponseWriter, r *http.Request) {
+	h.handleCommentVote(w, r, metrics.Up, h.store(r).UpvoteComment)
+}
+func (h *PostHandler) handleCommentDownvote(w http.ResponseWriter, r *http.Request) {
+	h.handleCommentVote(w, r, metrics.Down, h.store(r).DownvoteComment)
+}
+func (h *PostHandler) handleCommentUnvote(w http.ResponseWriter, r *http.Request) {
+	h.handleCommentVote(w, r, metrics.None, h.store(r).UnvoteComment)
+}
+
+func (h *PostHandler) handleCommentVote(w http.ResponseWriter, r *http.Request, direction string, voteFunc func(postID, commentID, userID string) (storage.Post, error)) {
+	user := r.Context().Value(USER).(UserClaims)
 
-	err = json.NewEncoder(w).Encode(&post)
+	post, err := voteFunc(r.PathValue("postID"), r.PathValue("commentID"), user.ID)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode post",
-		}})
+		writeStorageError(w, r, fmt.Errorf("vote: %w", err))
+		return
 	}
+	h.Metrics.Voted(direction)
+
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
 }
 
 type Comment struct {
@@ -183,53 +481,126 @@ type Comment struct {
 func (h *PostHandler) handleAddComment(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value(USER).(UserClaims)
 
-	var comment struct {
-		Message string `json:"comment"`
-	}
+	var comment Comment
 	err := json.NewDecoder(r.Body).Decode(&comment)
 	if err != nil {
-		http.Error(w, `{"message":"invalid comment POST body"}`, http.StatusBadRequest)
+		writeDecodeError(w, r, err, "invalid comment POST body")
+		return
+	}
+	if err := validate.Comment(comment.Comment); err != nil {
+		jsonError(w, r, http.StatusUnprocessableEntity, bodyErrors([]validate.Error{*err}))
 		return
 	}
 
 	postID := r.PathValue("id")
-	post, err := h.Storage.AddComment(postID, user.ID, user.Name, comment.Message)
+	post, err := h.store(r).AddComment(postID, user.ID, user.Name, comment.Comment)
+	if err != nil {
+		writeStorageError(w, r, fmt.Errorf("save comment: %w", err))
+		return
+	}
+	h.Metrics.CommentCreated()
+
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
+}
+
+func (h *PostHandler) handleAddReply(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(USER).(UserClaims)
+	postID, commentID := r.PathValue("postID"), r.PathValue("commentID")
+
+	var comment Comment
+	err := json.NewDecoder(r.Body).Decode(&comment)
+	if err != nil {
+		writeDecodeError(w, r, err, "invalid comment POST body")
+		return
+	}
+	if err := validate.Comment(comment.Comment); err != nil {
+		jsonError(w, r, http.StatusUnprocessableEntity, bodyErrors([]validate.Error{*err}))
+		return
+	}
+
+	post, err := h.store(r).AddReply(postID, commentID, user.ID, user.Name, comment.Comment)
+	if err != nil {
+		writeStorageError(w, r, fmt.Errorf("save reply: %w", err))
+		return
+	}
+	h.Metrics.CommentCreated()
+
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
+}
+
+// handleGetComments returns the comments of a post arranged into threads
+// and ordered by the sort query parameter.
+func (h *PostHandler) handleGetComments(w http.ResponseWriter, r *http.Request) {
+	hidden := h.hiddenAuthors(r)
+	post, err := h.store(r).GetPost(r.PathValue("id"))
+	if err == nil && (post.Moderation.Removed() || slices.Contains(hidden, post.Author.Name)) {
+		err = storage.ErrPostNotFound
+	}
 	if err != nil {
-		http.Error(w, `{"message":"invalid post id"}`, http.StatusBadRequest)
+		writeStorageError(w, r, err)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(&post)
+	sort := storage.CommentSort(r.URL.Query().Get("sort"))
+	threads, err := storage.BuildCommentThreads(storage.HideAuthors(post, hidden).Comments, sort)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode post",
+		jsonError(w, r, http.StatusBadRequest, []RequestError{{
+			Location: "query",
+			Param:    "sort",
+			Value:    string(sort),
+			Message:  err.Error(),
 		}})
+		return
 	}
+
+	writeJSON(w, r, http.StatusOK, threads)
 }
 
-func (h *PostHandler) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
-	user := r.Context().Value(USER).(UserClaims)
+func (h *PostHandler) handleEditComment(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
 	postID, commentID := r.PathValue("postID"), r.PathValue("commentID")
 
-	post, err := h.Storage.DeleteComment(postID, user.ID, commentID)
+	var comment Comment
+	err := json.NewDecoder(r.Body).Decode(&comment)
 	if err != nil {
-		var statusCode int
-		if errors.Is(err, storage.ErrPostNotFound) {
-			statusCode = http.StatusBadRequest
-		} else if errors.Is(err, storage.ErrPermissionDenied) {
-			statusCode = http.StatusForbidden
-		}
+		writeDecodeError(w, r, err, "invalid comment body")
+		return
+	}
+	if err := validate.Comment(comment.Comment); err != nil {
+		jsonError(w, r, http.StatusUnprocessableEntity, bodyErrors([]validate.Error{*err}))
+		return
+	}
 
-		http.Error(w, fmt.Sprintf("{\"message\":\"%s\"}", err.Error()), statusCode)
+	post, err := h.store(r).EditComment(postID, commentID, actor, comment.Comment)
+	if err != nil {
+		writeStorageError(w, r, fmt.Errorf("edit comment: %w", err))
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(&post)
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
+}
+
+func (h *PostHandler) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	actor := subject(r, h.Storage)
+	postID, commentID := r.PathValue("postID"), r.PathValue("commentID")
+
+	// the author is needed for the audit log and is gone after deletion
+	var author storage.PostAuthor
+	post, err := h.store(r).GetPost(postID)
+	if err == nil {
+		i := slices.IndexFunc(post.Comments, func(c storage.Comment) bool {
+			return c.ID == commentID
+		})
+		if i != -1 {
+			author = post.Comments[i].Author
+		}
+		post, err = h.store(r).DeleteComment(postID, actor, commentID)
+	}
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Location: "post",
-			Message:  "Failed to encode post",
-		}})
+		writeStorageError(w, r, fmt.Errorf("delete comment: %w", err))
+		return
 	}
+	h.auditContent(r, actor, authz.DeleteComment, author, post.Category, commentID)
+
+	h.writePost(w, r, http.StatusOK, post, h.hiddenAuthors(r))
 }
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// REQUEST holds the *requestLog of a request.
+const REQUEST key = "request"
+
+// RequestIDHeader carries the ID of a request. An ID sent by the client
+// or a proxy is kept, so its logs can be matched with ours.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestID is the maximum length of an incoming request ID.
+const maxRequestID = 128
+
+// requestLog collects the parts of a request's log entry that are only
+// known deeper in the handler chain.
+type requestLog struct {
+	ID     string
+	Logger *slog.Logger
+	Route  string
+	UserID string
+}
+
+// WithRequestLog writes a log entry for every request and gives handlers
+// a logger that tags their entries with the request ID.
+func WithRequestLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		entry := &requestLog{ID: id, Logger: logger.With("requestID", id)}
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), REQUEST, entry)))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", entry.Route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int64("bytes", rec.bytes),
+		}
+		if entry.UserID != "" {
+			attrs = append(attrs, slog.String("userID", entry.UserID))
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		entry.Logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII characters, anything else
+// could forge log entries.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Routed serves mux and records the pattern that matched the request in
+// its log entry. prefix is put before the path of the pattern, for muxes
+// mounted below the root.
+func Routed(prefix string, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value(REQUEST).(*requestLog); ok {
+			if route := routeOf(prefix, mux, r); route != "" {
+				entry.Route = route
+			}
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// routeOf returns the path of the mux pattern matching the request, with
+// prefix put before it, or "" if no pattern but the catch-all matches.
+func routeOf(prefix string, mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" || pattern == unmatchedPattern {
+		return ""
+	}
+
+	// the method of a pattern is recorded on its own
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = path
+	}
+	return prefix + pattern
+}
+
+// logUser adds the authenticated user to the log entry of the request.
+func logUser(r *http.Request, userID string) {
+	if entry, ok := r.Context().Value(REQUEST).(*requestLog); ok {
+		entry.UserID = userID
+	}
+}
+
+// requestLogger returns the logger of the request, the default logger
+// outside of WithRequestLog.
+func requestLogger(r *http.Request) *slog.Logger {
+	if entry, ok := r.Context().Value(REQUEST).(*requestLog); ok {
+		return entry.Logger
+	}
+	return slog.Default()
+}
+
+// responseRecorder records the status and the size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the original writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
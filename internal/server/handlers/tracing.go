@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"redditclone/internal/storage"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of the handlers. It does nothing until a
+// tracer provider is installed, see tracing.Install.
+var tracer = otel.Tracer("redditclone/internal/server/handlers")
+
+// withTracing starts the span of a request, continuing the trace of the
+// caller if the request carries one. Spans are named after the route.
+func withTracing(route func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		name := r.Method
+		attrs := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)),
+		}
+		if pattern := route(r); pattern != "" {
+			name += " " + pattern
+			attrs = append(attrs, trace.WithAttributes(semconv.HTTPRoute(pattern)))
+		}
+		ctx, span := tracer.Start(ctx, name, attrs...)
+		defer span.End()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// contextStorage is a storage that traces its calls as part of a request,
+// see tracing.Storage.
+type contextStorage interface {
+	WithContext(ctx context.Context) storage.Storage
+}
+
+// traced returns the storage s tracing its calls as part of the trace in
+// ctx. Untraced storages are returned as they are.
+func traced[S any](ctx context.Context, s S) S {
+	if c, ok := any(s).(contextStorage); ok {
+		if bound, ok := c.WithContext(ctx).(S); ok {
+			return bound
+		}
+	}
+	return s
+}
+
+func (h *UserHandler) store(r *http.Request) storage.UserStorage {
+	return traced(r.Context(), h.Storage)
+}
+
+func (h *PostHandler) store(r *http.Request) storage.Storage {
+	return traced(r.Context(), h.Storage)
+}
+
+func (h *CommunityHandler) store(r *http.Request) storage.Storage {
+	return traced(r.Context(), h.Storage)
+}
+
+func (h *AdminHandler) store(r *http.Request) storage.Storage {
+	return traced(r.Context(), h.Storage)
+}
+
+func (h *ModerationHandler) store(r *http.Request) storage.Storage {
+	return traced(r.Context(), h.Storage)
+}
@@ -4,12 +4,58 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"redditclone/internal/metrics"
+	"redditclone/internal/ratelimit"
 	"redditclone/internal/storage"
+	"redditclone/internal/validate"
+	"time"
 )
 
 type UserHandler struct {
-	Storage storage.UserStorage
+	Storage  storage.UserStorage
+	Tokens   *Tokens
+	Lockouts Lockouts
+	Metrics  *metrics.Metrics
+}
+
+// Lockouts throttle failed logins per account and per IP address. A nil
+// lockout never refuses a login.
+type Lockouts struct {
+	Account *ratelimit.Lockout
+	IP      *ratelimit.Lockout
+}
+
+// attempt reserves a login to the account from the IP address before the
+// password is checked, so parallel logins can not race past a lockout.
+func (l Lockouts) attempt(name, ip string, now time.Time) (time.Duration, bool) {
+	wait, ok := l.Account.Attempt(name, now)
+	if !ok {
+		return wait, false
+	}
+	if wait, ok = l.IP.Attempt(ip, now); !ok {
+		l.Account.Release(name)
+		return wait, false
+	}
+	return 0, true
+}
+
+func (l Lockouts) fail(name, ip string, now time.Time) {
+	l.Account.Fail(name, now)
+	l.IP.Fail(ip, now)
+}
+
+// succeed forgets the failures of the account, but logging into one's own
+// account does not clear the failures of the IP address.
+func (l Lockouts) succeed(name, ip string) {
+	l.Account.Reset(name)
+	l.IP.Release(ip)
+}
+
+func (l Lockouts) release(name, ip string) {
+	l.Account.Release(name)
+	l.IP.Release(ip)
 }
 
 type LogInRequest struct {
@@ -17,8 +63,8 @@ type LogInRequest struct {
 	Password string `json:"password"`
 }
 
-func NewUserHandler(storage storage.UserStorage) *UserHandler {
-	return &UserHandler{Storage: storage}
+func NewUserHandler(storage storage.UserStorage, tokens *Tokens, lockouts Lockouts, metrics *metrics.Metrics) *UserHandler {
+	return &UserHandler{Storage: storage, Tokens: tokens, Lockouts: lockouts, Metrics: metrics}
 }
 
 func (h *UserHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -26,16 +72,18 @@ func (h *UserHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, []RequestError{{
-			Location: "body",
-			Message:  "wrong request body, username & password expected",
-		}})
+		writeDecodeError(w, r, err, "wrong request body, username & password expected")
 		return
 	}
 
-	user, err := h.Storage.AddUser(req.UserName, req.Password)
+	if errs := validate.Registration(req.UserName, req.Password); len(errs) != 0 {
+		jsonError(w, r, http.StatusUnprocessableEntity, bodyErrors(errs))
+		return
+	}
+
+	user, err := h.store(r).AddUser(req.UserName, req.Password)
 	if errors.Is(err, storage.ErrUserAlreadyExists) {
-		jsonError(w, http.StatusUnprocessableEntity, []RequestError{{
+		jsonError(w, r, http.StatusUnprocessableEntity, []RequestError{{
 			Location: "body",
 			Param:    "username",
 			Value:    req.UserName,
@@ -43,64 +91,116 @@ func (h *UserHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		}})
 		return
 	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("register: %w", err))
+		return
+	}
+	h.Metrics.Registered()
 
-	token, err := generateJWT(user)
+	tokens, err := h.Tokens.startSession(r.Context(), user)
+	writeTokens(w, r, tokens, err)
+}
+
+func (h *UserHandler) handleLogIn(w http.ResponseWriter, r *http.Request) {
+	var req LogInRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Message: fmt.Sprintf("could not create token: %v", err),
-		}})
+		writeDecodeError(w, r, err, "wrong request body, username & password expected")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(
-		struct {
-			Token string `json:"token"`
-		}{token},
-	)
+	// unknown users are throttled like known ones, so lockouts do not
+	// tell which names exist
+	now, ip := time.Now(), clientIP(r)
+	wait, ok := h.Lockouts.attempt(req.UserName, ip, now)
+	if !ok {
+		writeTooManyRequests(w, r, wait, "too many failed login attempts")
+		return
+	}
 
+	user, err := h.store(r).GetUser(req.UserName, req.Password)
+	if errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrInvalidPassword) {
+		h.Metrics.LoggedIn(false)
+		h.Lockouts.fail(req.UserName, ip, time.Now())
+		writeError(w, r, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.Lockouts.release(req.UserName, ip)
+		writeInternalError(w, r, fmt.Errorf("log in: %w", err))
 		return
 	}
+	h.Metrics.LoggedIn(true)
+	h.Lockouts.succeed(req.UserName, ip)
+	if user.Ban.Effective(time.Now()) == storage.BanPermanent {
+		writeBanned(w, r, user.Ban)
+		return
+	}
+
+	tokens, err := h.Tokens.startSession(r.Context(), user)
+	writeTokens(w, r, tokens, err)
 }
 
-func (h *UserHandler) handleLogIn(w http.ResponseWriter, r *http.Request) {
-	var req LogInRequest
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (h *UserHandler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
 
 	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		jsonError(w, http.StatusBadRequest, []RequestError{{
+	if err != nil || req.RefreshToken == "" {
+		jsonError(w, r, http.StatusBadRequest, []RequestError{{
 			Location: "body",
-			Message:  "wrong request body, username & password expected",
+			Message:  "wrong request body, refreshToken expected",
 		}})
 		return
 	}
 
-	user, err := h.Storage.GetUser(req.UserName, req.Password)
-	if err != nil {
-		msg := fmt.Sprintf("{\"message\":\"%s\"}", err.Error())
-		http.Error(w, msg, http.StatusUnauthorized)
+	tokens, err := h.Tokens.refresh(r.Context(), req.RefreshToken)
+	if errors.Is(err, storage.ErrSessionNotFound) || errors.Is(err, storage.ErrRefreshTokenReused) {
+		writeError(w, r, http.StatusUnauthorized, "invalid refresh token")
 		return
 	}
 
-	token, err := generateJWT(user)
-	if err != nil {
-		jsonError(w, http.StatusInternalServerError, []RequestError{{
-			Message: fmt.Sprintf("could not create token: %v", err),
-		}})
+	writeTokens(w, r, tokens, err)
+}
+
+type LogOutRequest struct {
+	// All ends every session of the user instead of the current one.
+	All bool `json:"all"`
+}
+
+func (h *UserHandler) handleLogOut(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(USER).(UserClaims)
+	sessionID := r.Context().Value(SESSION).(string)
+
+	var req LogOutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err, "wrong request body")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(
-		struct {
-			Token string `json:"token"`
-		}{token},
-	)
+	if req.All {
+		err = traced(r.Context(), h.Tokens.sessions).DeleteUserSessions(user.ID)
+	} else {
+		err = traced(r.Context(), h.Tokens.sessions).DeleteSession(sessionID)
+	}
+	if err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+		writeInternalError(w, r, fmt.Errorf("log out: %w", err))
+		return
+	}
 
+	writeSuccess(w, r, http.StatusOK)
+}
+
+func writeTokens(w http.ResponseWriter, r *http.Request, tokens TokenPair, err error) {
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("create token: %w", err))
 		return
 	}
+
+	writeJSON(w, r, http.StatusOK, tokens)
 }
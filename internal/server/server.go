@@ -1,50 +1,459 @@
 package server
 
 import (
-	"log"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"redditclone/internal/authz"
+	"redditclone/internal/config"
+	"redditclone/internal/metrics"
+	"redditclone/internal/ratelimit"
 	"redditclone/internal/server/handlers"
 	"redditclone/internal/storage"
+	"redditclone/internal/tracing"
+	"redditclone/internal/views"
+	"sync"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type Service struct {
-	Server  *http.Server
+	Server *http.Server
+	// Admin serves the metrics on their own address, it is nil when they
+	// are served by Server or disabled.
+	Admin   *http.Server
 	Storage storage.Storage
+	Views   *views.Counter
+	// Tracing exports the spans of the service, it is nil when tracing
+	// is off.
+	Tracing *sdktrace.TracerProvider
+	// ShutdownTimeout limits how long Run waits for in-flight requests.
+	ShutdownTimeout time.Duration
+
+	listener      net.Listener
+	adminListener net.Listener
+	// serveErr receives the errors that stopped serving, if any, and is
+	// closed when all servers stopped.
+	serveErr     chan error
+	shutdownOnce sync.Once
+	shutdownErr  error
 }
 
-const PORT = ":8081"
+func NewService(cfg config.Config) (*Service, error) {
+	logger := newLogger(cfg.Log, os.Stderr)
+	// the log package and packages without a logger of their own write
+	// through it too
+	slog.SetDefault(logger)
+
+	storage, err := newStorage(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = seedCommunities(storage)
+	if err != nil {
+		closeStorage(storage)
+		return nil, err
+	}
+
+	err = promoteAdmins(storage, cfg.Admins)
+	if err != nil {
+		closeStorage(storage)
+		return nil, err
+	}
+
+	keys, err := loadKeys(cfg.JWT)
+	if err != nil {
+		closeStorage(storage)
+		return nil, err
+	}
 
-func NewService() Service {
-	storage := storage.NewInMemStorage()
+	tracer, err := newTracerProvider(cfg.Tracing)
+	if err != nil {
+		closeStorage(storage)
+		return nil, err
+	}
+	// the metrics read the storage undecorated, every scrape would
+	// trace its storage calls otherwise
+	counted := storage
+	if tracer != nil {
+		storage = tracing.NewStorage(storage)
+	}
 
+	views := views.NewCounter(storage, cfg.Views.Window, cfg.Views.FlushInterval)
+
+	var m *metrics.Metrics
+	var admin *http.Server
 	mux := http.NewServeMux()
-	registerStaticHandlers(mux)
-	handlers.ReqisterAPIHandlers(mux, storage)
+	if cfg.Metrics.Enabled {
+		m = metrics.New(counted)
+		if cfg.Metrics.Addr == "" {
+			mux.Handle("GET /metrics", m.Handler())
+		} else {
+			adminMux := http.NewServeMux()
+			adminMux.Handle("GET /metrics", m.Handler())
+			admin = &http.Server{
+				Addr:         cfg.Metrics.Addr,
+				Handler:      adminMux,
+				ReadTimeout:  cfg.ReadTimeout,
+				WriteTimeout: cfg.WriteTimeout,
+				IdleTimeout:  cfg.IdleTimeout,
+			}
+		}
+	}
+	registerStaticHandlers(mux, cfg.Assets)
+	handlers.ReqisterAPIHandlers(mux, handlers.Options{
+		Storage:        storage,
+		Views:          views,
+		Tokens:         handlers.NewTokens(keys, storage, storage, cfg.JWT.TTL, cfg.JWT.RefreshTTL),
+		RateLimits:     newRateLimits(cfg.RateLimit),
+		Lockouts:       newLockouts(cfg.Lockout),
+		MaxBodyBytes:   cfg.MaxBodyBytes,
+		ProblemDetails: cfg.ProblemDetails,
+		Metrics:        m,
+	})
 
-	log.Println("Starting server on :8081")
 	server := &http.Server{
-		Addr:         PORT,
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:         cfg.Addr,
+		Handler:      handlers.WithRequestLog(logger, handlers.Routed("", mux)),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	return &Service{
+		Server:          server,
+		Admin:           admin,
+		Storage:         storage,
+		Views:           views,
+		Tracing:         tracer,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+	}, nil
+}
+
+func newLogger(cfg config.LogConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Format == config.LogJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// newTracerProvider installs the tracer provider of the config, nil when
+// tracing is off.
+func newTracerProvider(cfg config.TracingConfig) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case config.TraceNone:
+		return nil, nil
+	case config.TraceStdout:
+		exporter, err = stdouttrace.New()
+	case config.TraceFile:
+		exporter, err = tracing.NewFileExporter(cfg.File)
+	case config.TraceOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trace exporter: %w", err)
+	}
+
+	return tracing.Install(exporter, cfg.SampleRatio)
+}
+
+func newStorage(cfg config.StorageConfig) (storage.Storage, error) {
+	switch cfg.Backend {
+	case config.MemoryStorage:
+		return storage.NewInMemStorage(), nil
+	case config.FileStorage:
+		return storage.NewFileStorage(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// loadKeys reads the JWT keys of the config. Without any key configured
+// a random HS256 secret is used.
+func loadKeys(cfg config.JWTConfig) (*handlers.KeySet, error) {
+	var keys []handlers.Key
+
+	if cfg.Secret != "" {
+		key, err := handlers.ParseKey(config.DefaultJWTKey, handlers.HS256, []byte(cfg.Secret))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, k := range cfg.Keys {
+		material := []byte(k.Secret)
+		if k.File != "" {
+			data, err := os.ReadFile(k.File)
+			if err != nil {
+				return nil, fmt.Errorf("read key %s: %w", k.ID, err)
+			}
+			material = data
+			if k.Algorithm == handlers.HS256 {
+				material = bytes.TrimSpace(data)
+			}
+		}
+
+		key, err := handlers.ParseKey(k.ID, k.Algorithm, material)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		slog.Warn("No JWT keys configured, using a random secret: tokens will not survive a restart")
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		key, err := handlers.ParseKey(config.DefaultJWTKey, handlers.HS256, secret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
 	}
 
-	return Service{
-		Server:  server,
-		Storage: storage,
+	signingKey := cfg.SigningKey
+	if signingKey == "" {
+		signingKey = config.DefaultJWTKey
 	}
+
+	return handlers.NewKeySet(keys, signingKey)
 }
 
+// newRateLimits creates the request budgets of the config, all of them
+// sharing one in-memory store.
+func newRateLimits(cfg config.RateLimitConfig) handlers.RateLimits {
+	if !cfg.Enabled {
+		return handlers.RateLimits{}
+	}
+
+	store := ratelimit.NewMemoryStore()
+	limiter := func(name string, l config.RateLimit) *ratelimit.Limiter {
+		return ratelimit.New(name, ratelimit.Limit{Requests: l.Requests, Period: l.Period, Burst: l.Burst}, store)
+	}
+
+	return handlers.RateLimits{
+		Login:    limiter("login", cfg.Login),
+		Register: limiter("register", cfg.Register),
+		Post:     limiter("post", cfg.Post),
+		Comment:  limiter("comment", cfg.Comment),
+		Vote:     limiter("vote", cfg.Vote),
+	}
+}
+
+func newLockouts(cfg config.LockoutConfig) handlers.Lockouts {
+	if !cfg.Enabled {
+		return handlers.Lockouts{}
+	}
+
+	lockout := func(p config.LockoutPolicy) *ratelimit.Lockout {
+		return ratelimit.NewLockout(ratelimit.Policy{
+			FreeAttempts:    p.FreeAttempts,
+			Delay:           p.Delay,
+			LockoutAttempts: p.LockoutAttempts,
+			LockoutDuration: p.LockoutDuration,
+		})
+	}
+
+	return handlers.Lockouts{Account: lockout(cfg.Account), IP: lockout(cfg.IP)}
+}
+
+// defaultCommunities are the categories the frontend offers to post in.
+var defaultCommunities = []string{"music", "funny", "videos", "programming", "news", "fashion"}
+
+func seedCommunities(s storage.CommunityStorage) error {
+	for _, name := range defaultCommunities {
+		_, err := s.AddCommunity(storage.Community{Name: name})
+		if err != nil && !errors.Is(err, storage.ErrCommunityAlreadyExists) {
+			return fmt.Errorf("create community %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// promoteAdmins gives the configured users the admin role. Users that have
+// not registered yet are promoted on the next start.
+func promoteAdmins(s storage.UserStorage, names []string) error {
+	for _, name := range names {
+		_, err := s.SetUserRole(name, authz.RoleAdmin)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			slog.Warn("Admin is not registered yet", "name", name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("promote admin %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Run serves until SIGINT or SIGTERM is received or serving fails, and then
+// shuts the service down gracefully.
 func (s *Service) Run() error {
-	return s.Server.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := s.Start()
+	if err != nil {
+		return err
+	}
+	slog.Info("Starting server", "addr", s.Addr().String())
+	if s.Admin != nil {
+		slog.Info("Serving metrics", "addr", s.AdminAddr().String())
+	}
+
+	select {
+	case err = <-s.serveErr:
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+
+	return errors.Join(err, s.Shutdown(shutdownCtx))
+}
+
+// Start listens on the configured addresses and serves requests in the
+// background. With port 0 a random free port is used, see Addr.
+func (s *Service) Start() error {
+	listener, err := net.Listen("tcp", s.Server.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	if s.Admin != nil {
+		s.adminListener, err = net.Listen("tcp", s.Admin.Addr)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("admin listener: %w", err)
+		}
+	}
+
+	s.serveErr = make(chan error, 2)
+	var wg sync.WaitGroup
+	serve := func(server *http.Server, listener net.Listener) {
+		wg.Go(func() {
+			err := server.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				s.serveErr <- err
+			}
+		})
+	}
+	serve(s.Server, listener)
+	if s.Admin != nil {
+		serve(s.Admin, s.adminListener)
+	}
+	go func() {
+		wg.Wait()
+		close(s.serveErr)
+	}()
+
+	return nil
+}
+
+// Addr returns the address the service listens on after Start.
+func (s *Service) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// AdminAddr returns the address of the admin listener after Start, nil
+// without one.
+func (s *Service) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// until ctx is done, then drops the remaining ones. Buffered views are
+// flushed and the storage is closed afterwards. It is safe to call
+// Shutdown more than once.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		var errs []error
+
+		err := s.Server.Shutdown(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("shutdown http server: %w", err))
+			s.Server.Close()
+		}
+		if s.Admin != nil {
+			err = s.Admin.Shutdown(ctx)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("shutdown admin server: %w", err))
+				s.Admin.Close()
+			}
+		}
+		if s.serveErr != nil {
+			// wait for all servers to stop
+			for range s.serveErr {
+			}
+		}
+
+		err = s.Views.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("flush views: %w", err))
+		}
+
+		err = closeStorage(s.Storage)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("close storage: %w", err))
+		}
+
+		if s.Tracing != nil {
+			err = s.Tracing.Shutdown(ctx)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("flush traces: %w", err))
+			}
+		}
+
+		s.shutdownErr = errors.Join(errs...)
+	})
+
+	return s.shutdownErr
+}
+
+// closeStorage closes storage backends that hold resources.
+func closeStorage(s storage.Storage) error {
+	closer, ok := s.(io.Closer)
+	if !ok {
+		return nil
+	}
+
+	return closer.Close()
 }
 
-func registerStaticHandlers(mux *http.ServeMux) {
-	staticHTMLHandler := http.FileServer(http.Dir("./web/html"))
+func registerStaticHandlers(mux *http.ServeMux, cfg config.AssetsConfig) {
+	staticHTMLHandler := http.FileServer(http.Dir(cfg.HTMLDir))
 	mux.Handle("/", staticHTMLHandler)
 
-	staticHandler := http.FileServer(http.Dir("./web"))
+	staticHandler := http.FileServer(http.Dir(cfg.StaticDir))
 	mux.Handle("/static/", http.StripPrefix("/static/", staticHandler))
 }
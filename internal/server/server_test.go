@@ -0,0 +1,997 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"redditclone/internal/authz"
+	"redditclone/internal/config"
+	"redditclone/internal/server"
+	"redditclone/internal/server/handlers"
+	"redditclone/internal/storage"
+)
+
+// testPassword passes the password policy.
+const testPassword = "tumbling-otter-42"
+
+func testConfig(t *testing.T) config.Config {
+	cfg := config.Default()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.Assets.HTMLDir = "../../web/html"
+	cfg.Assets.StaticDir = "../../web"
+	cfg.JWT.Secret = "integration-test-secret"
+	cfg.Storage.Backend = config.FileStorage
+	cfg.Storage.Path = filepath.Join(t.TempDir(), "data.db")
+	return cfg
+}
+
+func startService(t *testing.T, cfg config.Config) (*server.Service, string) {
+	t.Helper()
+
+	s, err := server.NewService(cfg)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	return s, fmt.Sprintf("http://%s/api", s.Addr())
+}
+
+func do(t *testing.T, method, url, token string, body any, out any) int {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reqBody).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	cfg := testConfig(t)
+	s, api := startService(t, cfg)
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	code := do(t, "POST", api+"/register", "", map[string]string{"username": "alice", "password": testPassword}, &auth)
+	if code != http.StatusOK || auth.Token == "" {
+		t.Fatalf("register: status %d", code)
+	}
+
+	var post storage.Post
+	code = do(t, "POST", api+"/posts", auth.Token, map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}, &post)
+	if code != http.StatusCreated {
+		t.Fatalf("new post: status %d", code)
+	}
+
+	code = do(t, "GET", api+"/post/"+post.ID, "", nil, &post)
+	if code != http.StatusOK || post.Views != 2 {
+		t.Fatalf("get post: status %d, views %d, want 200 and 2", code, post.Views)
+	}
+
+	err := s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	_, err = http.Get(api + "/posts/")
+	if err == nil {
+		t.Error("service still serves after Shutdown")
+	}
+
+	// the data and the buffered view survive a restart
+	_, api = startService(t, cfg)
+	code = do(t, "GET", api+"/post/"+post.ID, auth.Token, nil, &post)
+	if code != http.StatusOK || post.Views != 3 || post.Title != "hello" {
+		t.Errorf("get post after restart: status %d, views %d, title %q", code, post.Views, post.Title)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ed25519.pem")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(t)
+	cfg.JWT.Secret = ""
+	cfg.JWT.Keys = []config.JWTKey{
+		{ID: "old", Algorithm: "HS256", Secret: "old-integration-secret"},
+		{ID: "new", Algorithm: "EdDSA", File: keyFile},
+	}
+	cfg.JWT.SigningKey = "old"
+	err = cfg.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	credentials := map[string]string{"username": "alice", "password": testPassword}
+	newPost := map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}
+
+	s, api := startService(t, cfg)
+	do(t, "POST", api+"/register", "", credentials, &auth)
+	oldToken := auth.Token
+	s.Shutdown(context.Background())
+
+	// tokens of the previous signing key stay valid while it is configured
+	cfg.JWT.SigningKey = "new"
+	s, api = startService(t, cfg)
+	code := do(t, "POST", api+"/posts", oldToken, newPost, nil)
+	if code != http.StatusCreated {
+		t.Errorf("new post with old token: status %d, want 201", code)
+	}
+	do(t, "POST", api+"/login", "", credentials, &auth)
+	code = do(t, "POST", api+"/posts", auth.Token, newPost, nil)
+	if code != http.StatusCreated {
+		t.Errorf("new post with new token: status %d, want 201", code)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			ID      string `json:"kid"`
+			X       string `json:"x"`
+		} `json:"keys"`
+	}
+	code = do(t, "GET", fmt.Sprintf("http://%s/.well-known/jwks.json", s.Addr()), "", nil, &jwks)
+	if code != http.StatusOK || len(jwks.Keys) != 1 || jwks.Keys[0].ID != "new" || jwks.Keys[0].KeyType != "OKP" {
+		t.Errorf("jwks: status %d, keys %+v, want only the public EdDSA key", code, jwks.Keys)
+	}
+	s.Shutdown(context.Background())
+
+	// removing the old key revokes its tokens
+	cfg.JWT.Keys = cfg.JWT.Keys[1:]
+	_, api = startService(t, cfg)
+	code = do(t, "POST", api+"/posts", oldToken, newPost, nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("new post with removed key: status %d, want 401", code)
+	}
+	code = do(t, "POST", api+"/posts", auth.Token, newPost, nil)
+	if code != http.StatusCreated {
+		t.Errorf("new post with current key: status %d, want 201", code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	_, api := startService(t, testConfig(t))
+
+	type tokenPair struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	credentials := map[string]string{"username": "alice", "password": testPassword}
+	newPost := map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}
+	refresh := func(refreshToken string) (tokenPair, int) {
+		var pair tokenPair
+		code := do(t, "POST", api+"/token/refresh", "", map[string]string{"refreshToken": refreshToken}, &pair)
+		return pair, code
+	}
+
+	var first tokenPair
+	do(t, "POST", api+"/register", "", credentials, &first)
+	if first.Token == "" || first.RefreshToken == "" {
+		t.Fatalf("register returned %+v", first)
+	}
+
+	second, code := refresh(first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: status %d, pair %+v", code, second)
+	}
+	if code = do(t, "POST", api+"/posts", second.Token, newPost, nil); code != http.StatusCreated {
+		t.Errorf("new post with refreshed token: status %d, want 201", code)
+	}
+
+	// replaying a rotated refresh token ends the session
+	if _, code = refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh with rotated token: status %d, want 401", code)
+	}
+	if _, code = refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after reuse: status %d, want 401", code)
+	}
+	if code = do(t, "POST", api+"/posts", second.Token, newPost, nil); code != http.StatusUnauthorized {
+		t.Errorf("new post after reuse: status %d, want 401", code)
+	}
+
+	var laptop, phone tokenPair
+	do(t, "POST", api+"/login", "", credentials, &laptop)
+	do(t, "POST", api+"/login", "", credentials, &phone)
+
+	if code = do(t, "POST", api+"/logout", laptop.Token, nil, nil); code != http.StatusOK {
+		t.Fatalf("logout: status %d", code)
+	}
+	if code = do(t, "POST", api+"/posts", laptop.Token, newPost, nil); code != http.StatusUnauthorized {
+		t.Errorf("new post after logout: status %d, want 401", code)
+	}
+	if code = do(t, "POST", api+"/posts", phone.Token, newPost, nil); code != http.StatusCreated {
+		t.Errorf("new post from another session after logout: status %d, want 201", code)
+	}
+
+	do(t, "POST", api+"/login", "", credentials, &laptop)
+	if code = do(t, "POST", api+"/logout", laptop.Token, map[string]bool{"all": true}, nil); code != http.StatusOK {
+		t.Fatalf("logout all: status %d", code)
+	}
+	if code = do(t, "POST", api+"/posts", phone.Token, newPost, nil); code != http.StatusUnauthorized {
+		t.Errorf("new post after logout all: status %d, want 401", code)
+	}
+	if _, code = refresh(phone.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout all: status %d, want 401", code)
+	}
+}
+
+// startWithUsers registers the users with testPassword and
+// restarts the service, so configured admins are promoted. It returns the
+// API URL and the tokens of the users.
+func startWithUsers(t *testing.T, cfg config.Config, names ...string) (string, map[string]string) {
+	t.Helper()
+
+	s, api := startService(t, cfg)
+	for _, name := range names {
+		do(t, "POST", api+"/register", "", map[string]string{"username": name, "password": testPassword}, nil)
+	}
+	s.Shutdown(context.Background())
+
+	_, api = startService(t, cfg)
+	tokens := map[string]string{}
+	for _, name := range names {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		do(t, "POST", api+"/login", "", map[string]string{"username": name, "password": testPassword}, &auth)
+		tokens[name] = auth.Token
+	}
+
+	return api, tokens
+}
+
+func TestUserPosts(t *testing.T) {
+	api, tokens := startWithUsers(t, testConfig(t), "alice", "bob")
+
+	want := map[string]bool{}
+	for _, title := range []string{"one", "two", "three"} {
+		var post storage.Post
+		do(t, "POST", api+"/posts", tokens["alice"], map[string]string{"type": "text", "category": "news", "title": title, "text": "text"}, &post)
+		want[post.ID] = true
+	}
+	do(t, "POST", api+"/posts", tokens["bob"], map[string]string{"type": "text", "category": "news", "title": "other", "text": "text"}, nil)
+
+	got := map[string]bool{}
+	url := api + "/user/alice?limit=2"
+	for pages := 0; url != ""; pages++ {
+		if pages == 2 {
+			t.Fatal("more than 2 pages of 3 posts with limit 2")
+		}
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		var posts []storage.Post
+		err = json.NewDecoder(resp.Body).Decode(&posts)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("GET %s: decode response: %v", url, err)
+		}
+		for _, post := range posts {
+			if !want[post.ID] || got[post.ID] {
+				t.Errorf("unexpected or repeated post %+v", post)
+			}
+			got[post.ID] = true
+		}
+
+		url = ""
+		for _, link := range strings.Split(resp.Header.Get("Link"), ", ") {
+			if query, ok := strings.CutSuffix(link, `>; rel="next"`); ok {
+				url = api + "/user/alice" + strings.TrimPrefix(query, "<")
+			}
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("listed %d posts of alice, want %d", len(got), len(want))
+	}
+
+	if code := do(t, "GET", api+"/user/alice?limit=0", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid limit: status %d, want 400", code)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Admins = []string{"root"}
+
+	api, tokens := startWithUsers(t, cfg, "root", "alice", "bob")
+
+	var post storage.Post
+	do(t, "POST", api+"/posts", tokens["alice"], map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}, &post)
+
+	if code := do(t, "GET", api+"/post/"+post.ID+"/revisions", tokens["bob"], nil, nil); code != http.StatusForbidden {
+		t.Errorf("revisions by stranger: status %d, want 403", code)
+	}
+	if code := do(t, "POST", api+"/community/news/moderators", tokens["alice"], map[string]string{"username": "bob"}, nil); code != http.StatusForbidden {
+		t.Errorf("add moderator by user: status %d, want 403", code)
+	}
+	var news storage.Community
+	code := do(t, "POST", api+"/community/news/moderators", tokens["root"], map[string]string{"username": "bob"}, &news)
+	if code != http.StatusOK || len(news.Moderators) != 1 || news.Moderators[0].Name != "bob" {
+		t.Fatalf("add moderator by admin: status %d, moderators %v", code, news.Moderators)
+	}
+
+	if code = do(t, "GET", api+"/post/"+post.ID+"/revisions", tokens["bob"], nil, nil); code != http.StatusOK {
+		t.Errorf("revisions by moderator: status %d, want 200", code)
+	}
+	if code = do(t, "PUT", api+"/post/"+post.ID, tokens["bob"], map[string]string{"text": "edited"}, nil); code != http.StatusForbidden {
+		t.Errorf("edit by moderator: status %d, want 403", code)
+	}
+	if code = do(t, "DELETE", api+"/post/"+post.ID, tokens["bob"], nil, nil); code != http.StatusOK {
+		t.Errorf("delete by moderator: status %d, want 200", code)
+	}
+
+	if code = do(t, "GET", api+"/admin/audit", tokens["bob"], nil, nil); code != http.StatusForbidden {
+		t.Errorf("audit log by moderator: status %d, want 403", code)
+	}
+	var log []storage.AuditEntry
+	code = do(t, "GET", api+"/admin/audit", tokens["root"], nil, &log)
+	if code != http.StatusOK || len(log) != 3 {
+		t.Fatalf("audit log: status %d, entries %+v", code, log)
+	}
+	if log[0].Action != authz.DeletePost || log[0].Actor.Name != "bob" || log[0].Role != "moderator" || log[0].Target != post.ID {
+		t.Errorf("audit log entry of deletion = %+v", log[0])
+	}
+	if log[2].Action != authz.ManageModerators || log[2].Actor.Name != "root" || log[2].Role != "admin" {
+		t.Errorf("audit log entry of appointment = %+v", log[2])
+	}
+
+	if code = do(t, "PUT", api+"/admin/users/alice/role", tokens["bob"], map[string]string{"role": "admin"}, nil); code != http.StatusForbidden {
+		t.Errorf("set role by moderator: status %d, want 403", code)
+	}
+	if code = do(t, "PUT", api+"/admin/users/alice/role", tokens["root"], map[string]string{"role": "owner"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("set unknown role: status %d, want 422", code)
+	}
+	if code = do(t, "PUT", api+"/admin/users/alice/role", tokens["root"], map[string]string{"role": "admin"}, nil); code != http.StatusOK {
+		t.Errorf("set role by admin: status %d, want 200", code)
+	}
+}
+
+func TestModQueue(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Admins = []string{"root"}
+	api, tokens := startWithUsers(t, cfg, "root", "alice", "bob")
+
+	var post storage.Post
+	do(t, "POST", api+"/posts", tokens["alice"], map[string]string{"type": "text", "category": "news", "title": "buy now", "text": "cheap"}, &post)
+	do(t, "POST", api+"/post/"+post.ID, tokens["alice"], map[string]string{"comment": "really cheap"}, &post)
+	commentID := post.Comments[0].ID
+
+	if code := do(t, "POST", api+"/post/"+post.ID+"/report", tokens["bob"], map[string]string{"reason": ""}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("report without reason: status %d, want 422", code)
+	}
+	if code := do(t, "POST", api+"/post/"+post.ID+"/report", tokens["bob"], map[string]string{"reason": "spam"}, nil); code != http.StatusCreated {
+		t.Errorf("report post: status %d, want 201", code)
+	}
+	if code := do(t, "POST", api+"/post/"+post.ID+"/"+commentID+"/report", tokens["bob"], map[string]string{"reason": "spam"}, nil); code != http.StatusCreated {
+		t.Errorf("report comment: status %d, want 201", code)
+	}
+
+	if code := do(t, "GET", api+"/modqueue", tokens["bob"], nil, nil); code != http.StatusForbidden {
+		t.Errorf("queue of user: status %d, want 403", code)
+	}
+	var queue []storage.QueueItem
+	if code := do(t, "GET", api+"/modqueue", tokens["root"], nil, &queue); code != http.StatusOK || len(queue) != 2 {
+		t.Fatalf("queue of admin: status %d, items %+v", code, queue)
+	}
+
+	if code := do(t, "POST", api+"/modqueue/"+post.ID+"/remove", tokens["bob"], nil, nil); code != http.StatusForbidden {
+		t.Errorf("remove by user: status %d, want 403", code)
+	}
+	if code := do(t, "POST", api+"/modqueue/"+post.ID+"/remove", tokens["root"], nil, nil); code != http.StatusOK {
+		t.Fatalf("remove post: status %d", code)
+	}
+	var posts []storage.Post
+	do(t, "GET", api+"/posts/", "", nil, &posts)
+	if len(posts) != 0 {
+		t.Errorf("listing after removal returned %d posts, want 0", len(posts))
+	}
+	if code := do(t, "GET", api+"/post/"+post.ID, "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("removed post: status %d, want 404", code)
+	}
+	if code := do(t, "POST", api+"/modqueue/"+post.ID+"/"+commentID+"/dismiss", tokens["root"], nil, nil); code != http.StatusOK {
+		t.Errorf("dismiss comment under removed post: status %d, want 200", code)
+	}
+	if code := do(t, "POST", api+"/modqueue/"+post.ID+"/unknown/dismiss", tokens["root"], nil, nil); code != http.StatusNotFound {
+		t.Errorf("dismiss unknown comment under removed post: status %d, want 404", code)
+	}
+
+	if code := do(t, "POST", api+"/modqueue/"+post.ID+"/approve", tokens["root"], nil, nil); code != http.StatusOK {
+		t.Fatalf("approve post: status %d", code)
+	}
+	do(t, "GET", api+"/posts/", "", nil, &posts)
+	if len(posts) != 1 {
+		t.Errorf("listing after approval returned %d posts, want 1", len(posts))
+	}
+
+	if code := do(t, "POST", api+"/modqueue/"+post.ID+"/"+commentID+"/remove", tokens["root"], nil, nil); code != http.StatusOK {
+		t.Fatalf("remove comment: status %d", code)
+	}
+	do(t, "GET", api+"/post/"+post.ID, "", nil, &post)
+	if c := post.Comments[0]; c.Body != storage.RemovedText || !c.Removed {
+		t.Errorf("removed comment is shown as %+v", c)
+	}
+
+	var log []storage.AuditEntry
+	do(t, "GET", api+"/admin/audit", tokens["root"], nil, &log)
+	if len(log) != 4 || log[0].Action != authz.Moderate || log[0].Target != commentID || log[0].Details != "removed" {
+		t.Errorf("audit log = %+v", log)
+	}
+}
+
+func TestBans(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Admins = []string{"root"}
+	api, tokens := startWithUsers(t, cfg, "root", "alice", "bob", "carol")
+
+	var post storage.Post
+	do(t, "POST", api+"/posts", tokens["alice"], map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}, &post)
+
+	if code := do(t, "PUT", api+"/admin/users/bob/ban", tokens["alice"], map[string]string{"kind": "shadowban"}, nil); code != http.StatusForbidden {
+		t.Errorf("ban by user: status %d, want 403", code)
+	}
+	if code := do(t, "PUT", api+"/admin/users/root/ban", tokens["root"], map[string]string{"kind": "ban"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("ban of admin: status %d, want 422", code)
+	}
+	if code := do(t, "PUT", api+"/admin/users/bob/ban", tokens["root"], map[string]string{"kind": "shadowban"}, nil); code != http.StatusOK {
+		t.Fatalf("shadowban: status %d", code)
+	}
+
+	var own storage.Post
+	if code := do(t, "POST", api+"/posts", tokens["bob"], map[string]string{"type": "text", "category": "news", "title": "spam", "text": "spam"}, &own); code != http.StatusCreated {
+		t.Fatalf("post by shadowbanned user: status %d, want 201", code)
+	}
+	do(t, "POST", api+"/post/"+post.ID, tokens["bob"], map[string]string{"comment": "spam"}, nil)
+
+	var posts []storage.Post
+	do(t, "GET", api+"/posts/", tokens["bob"], nil, &posts)
+	if len(posts) != 2 {
+		t.Errorf("shadowbanned user sees %d posts, want 2", len(posts))
+	}
+	do(t, "GET", api+"/posts/", "", nil, &posts)
+	if len(posts) != 1 || len(posts[0].Comments) != 0 {
+		t.Errorf("others see %d posts with %v, want only the post without comments", len(posts), posts)
+	}
+	if code := do(t, "GET", api+"/post/"+own.ID, tokens["alice"], nil, nil); code != http.StatusNotFound {
+		t.Errorf("shadowbanned post: status %d, want 404", code)
+	}
+	do(t, "GET", api+"/post/"+post.ID, tokens["bob"], nil, &post)
+	if len(post.Comments) != 1 {
+		t.Errorf("shadowbanned user sees %d of own comments, want 1", len(post.Comments))
+	}
+
+	var carols storage.Post
+	do(t, "POST", api+"/posts", tokens["carol"], map[string]string{"type": "text", "category": "news", "title": "hot take", "text": "take"}, &carols)
+	do(t, "POST", api+"/post/"+carols.ID, tokens["carol"], map[string]string{"comment": "more takes"}, &carols)
+
+	until := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if code := do(t, "PUT", api+"/admin/users/carol/ban", tokens["root"], map[string]string{"kind": "suspension"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("suspension without end: status %d, want 422", code)
+	}
+	do(t, "PUT", api+"/admin/users/carol/ban", tokens["root"], map[string]string{"kind": "suspension", "reason": "flaming", "until": until}, nil)
+	var notice handlers.BanNotice
+	if code := do(t, "GET", api+"/post/"+post.ID+"/upvote", tokens["carol"], nil, &notice); code != http.StatusForbidden {
+		t.Errorf("vote by suspended user: status %d, want 403", code)
+	}
+	if notice.Message != "account suspended" || notice.Reason != "flaming" || notice.Until.Format(time.RFC3339) != until {
+		t.Errorf("suspension notice = %+v", notice)
+	}
+	if code := do(t, "DELETE", api+"/post/"+carols.ID+"/"+carols.Comments[0].ID, tokens["carol"], nil, nil); code != http.StatusForbidden {
+		t.Errorf("comment deletion by suspended user: status %d, want 403", code)
+	}
+	if code := do(t, "DELETE", api+"/post/"+carols.ID, tokens["carol"], nil, nil); code != http.StatusForbidden {
+		t.Errorf("post deletion by suspended user: status %d, want 403", code)
+	}
+	if code := do(t, "POST", api+"/logout", tokens["carol"], nil, nil); code != http.StatusOK {
+		t.Errorf("logout by suspended user: status %d, want 200", code)
+	}
+
+	do(t, "PUT", api+"/admin/users/alice/ban", tokens["root"], map[string]string{"kind": "ban", "reason": "spam"}, nil)
+	if code := do(t, "GET", api+"/post/"+post.ID+"/upvote", tokens["alice"], nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("token of banned user: status %d, want 401", code)
+	}
+	notice = handlers.BanNotice{}
+	if code := do(t, "POST", api+"/login", "", map[string]string{"username": "alice", "password": testPassword}, &notice); code != http.StatusForbidden || notice.Kind != storage.BanPermanent {
+		t.Errorf("login of banned user: status %d, notice %+v", code, notice)
+	}
+
+	if code := do(t, "DELETE", api+"/admin/users/alice/ban", tokens["root"], nil, nil); code != http.StatusOK {
+		t.Errorf("unban: status %d, want 200", code)
+	}
+	if code := do(t, "POST", api+"/login", "", map[string]string{"username": "alice", "password": testPassword}, nil); code != http.StatusOK {
+		t.Errorf("login after unban: status %d, want 200", code)
+	}
+
+	var log []storage.AuditEntry
+	do(t, "GET", api+"/admin/audit", tokens["root"], nil, &log)
+	if len(log) != 4 || log[0].Details != "unban" || log[1].Details != "ban: spam" {
+		t.Errorf("audit log = %+v", log)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.RateLimit.Login = config.RateLimit{Requests: 2, Period: time.Hour}
+	cfg.RateLimit.Vote = config.RateLimit{Requests: 1, Period: time.Minute}
+	api, tokens := startWithUsers(t, cfg, "alice", "bob")
+
+	// startWithUsers used up the login budget
+	resp, err := http.Post(api+"/login", "application/json", strings.NewReader(`{"username":"alice","password":"`+testPassword+`"}`))
+	if err != nil {
+		t.Fatalf("POST /login: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("login over budget: status %d, Retry-After %q, want 429 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	var post storage.Post
+	do(t, "POST", api+"/posts", tokens["alice"], map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}, &post)
+	if code := do(t, "GET", api+"/post/"+post.ID+"/upvote", tokens["alice"], nil, nil); code != http.StatusOK {
+		t.Errorf("first vote: status %d, want 200", code)
+	}
+	// every test request comes from the same IP, so its bucket is empty too
+	if code := do(t, "GET", api+"/post/"+post.ID+"/unvote", tokens["bob"], nil, nil); code != http.StatusTooManyRequests {
+		t.Errorf("vote over budget: status %d, want 429", code)
+	}
+	if code := do(t, "GET", api+"/post/"+post.ID, "", nil, nil); code != http.StatusOK {
+		t.Errorf("reading over the vote budget: status %d, want 200", code)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Lockout.Account = config.LockoutPolicy{FreeAttempts: 1, Delay: time.Hour, LockoutAttempts: 3, LockoutDuration: time.Hour}
+	api, _ := startWithUsers(t, cfg, "alice", "bob")
+
+	login := func(name, password string) (int, string) {
+		var resp struct {
+			Message string `json:"message"`
+		}
+		code := do(t, "POST", api+"/login", "", map[string]string{"username": name, "password": password}, &resp)
+		return code, resp.Message
+	}
+
+	wrongCode, wrongMsg := login("alice", "wrong")
+	unknownCode, unknownMsg := login("nobody", "wrong")
+	if wrongCode != http.StatusUnauthorized || wrongCode != unknownCode || wrongMsg != unknownMsg {
+		t.Errorf("wrong password: %d %q, unknown user: %d %q, want the same 401", wrongCode, wrongMsg, unknownCode, unknownMsg)
+	}
+
+	if code, _ := login("alice", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("second failure: status %d, want 401", code)
+	}
+	if code, _ := login("alice", testPassword); code != http.StatusTooManyRequests {
+		t.Errorf("login during delay: status %d, want 429", code)
+	}
+	if code, _ := login("bob", testPassword); code != http.StatusOK {
+		t.Errorf("login of another account: status %d, want 200", code)
+	}
+}
+
+func TestConcurrentLoginLockout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.RateLimit.Enabled = false
+	cfg.Lockout.Account = config.LockoutPolicy{FreeAttempts: 2, Delay: time.Hour, LockoutAttempts: 3, LockoutDuration: time.Hour}
+	api, _ := startWithUsers(t, cfg, "alice")
+
+	body, _ := json.Marshal(map[string]string{"username": "alice", "password": "wrong"})
+	codes := make([]int, 4*cfg.Lockout.Account.LockoutAttempts)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(api+"/login", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Errorf("login: %v", err)
+				return
+			}
+			resp.Body.Close()
+			codes[i] = resp.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	// only logins that got past the lockout check the password
+	checked := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("parallel login: status %d, want 401 or 429", code)
+		}
+	}
+	if checked == 0 || checked > cfg.Lockout.Account.LockoutAttempts {
+		t.Errorf("%d of %d parallel logins checked the password, want 1 to %d", checked, len(codes), cfg.Lockout.Account.LockoutAttempts)
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	_, api := startService(t, testConfig(t))
+
+	var resp struct {
+		Errors []struct {
+			Location string `json:"location"`
+			Param    string `json:"param"`
+			Value    string `json:"value"`
+		} `json:"errors"`
+	}
+	code := do(t, "POST", api+"/register", "", map[string]string{"username": "a b", "password": "password"}, &resp)
+	if code != http.StatusUnprocessableEntity || len(resp.Errors) != 2 {
+		t.Fatalf("invalid registration: status %d, errors %+v", code, resp.Errors)
+	}
+	if e := resp.Errors[0]; e.Location != "body" || e.Param != "username" || e.Value != "a b" {
+		t.Errorf("username error = %+v", e)
+	}
+	if e := resp.Errors[1]; e.Param != "password" || e.Value != "" {
+		t.Errorf("password error = %+v", e)
+	}
+
+	if code = do(t, "POST", api+"/register", "", map[string]string{"username": "alice", "password": testPassword}, nil); code != http.StatusOK {
+		t.Fatalf("valid registration: status %d", code)
+	}
+	resp.Errors = nil
+	code = do(t, "POST", api+"/register", "", map[string]string{"username": "Alice", "password": testPassword}, &resp)
+	if code != http.StatusUnprocessableEntity || len(resp.Errors) != 1 || resp.Errors[0].Param != "username" {
+		t.Errorf("registration of a name taken in another case: status %d, errors %+v", code, resp.Errors)
+	}
+}
+
+func TestPostValidation(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxBodyBytes = 4096
+	api, tokens := startWithUsers(t, cfg, "alice")
+	token := tokens["alice"]
+
+	type errorList struct {
+		Errors []struct {
+			Param string `json:"param"`
+		} `json:"errors"`
+	}
+	invalid := []struct {
+		body  map[string]string
+		param string
+	}{
+		{map[string]string{"type": "image", "category": "news", "title": "hello", "text": "world"}, "type"},
+		{map[string]string{"type": "text", "category": "news", "title": "", "text": "world"}, "title"},
+		{map[string]string{"type": "link", "category": "news", "title": "hello", "url": "javascript:alert(1)"}, "url"},
+	}
+	for _, tt := range invalid {
+		var resp errorList
+		code := do(t, "POST", api+"/posts", token, tt.body, &resp)
+		if code != http.StatusUnprocessableEntity || len(resp.Errors) != 1 || resp.Errors[0].Param != tt.param {
+			t.Errorf("POST /posts %v: status %d, errors %+v, want 422 for %s", tt.body, code, resp.Errors, tt.param)
+		}
+	}
+
+	huge := map[string]string{"type": "text", "category": "news", "title": "hello", "text": strings.Repeat("a", 5000)}
+	if code := do(t, "POST", api+"/posts", token, huge, nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized post: status %d, want 413", code)
+	}
+
+	var post struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	link := map[string]string{"type": "link", "category": "news", "title": "hello", "url": "https://example.com"}
+	if code := do(t, "POST", api+"/posts", token, link, &post); code != http.StatusCreated || post.URL != "https://example.com" {
+		t.Fatalf("link post: status %d, url %q", code, post.URL)
+	}
+
+	var resp errorList
+	if code := do(t, "POST", api+"/post/"+post.ID, token, map[string]string{"comment": " "}, &resp); code != http.StatusUnprocessableEntity || len(resp.Errors) != 1 || resp.Errors[0].Param != "comment" {
+		t.Errorf("blank comment: status %d, errors %+v", code, resp.Errors)
+	}
+	if code := do(t, "PUT", api+"/post/"+post.ID, token, map[string]string{"title": ""}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("edit to a blank title: status %d, want 422", code)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	api, tokens := startWithUsers(t, testConfig(t), "alice")
+	token := tokens["alice"]
+
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if code := do(t, "GET", api+"/post/unknown/upvote", token, nil, &msg); code != http.StatusNotFound || msg.Message != storage.ErrPostNotFound.Error() {
+		t.Errorf("vote on an unknown post: status %d, message %q, want 404", code, msg.Message)
+	}
+	if code := do(t, "DELETE", api+"/post/unknown", token, nil, nil); code != http.StatusNotFound {
+		t.Errorf("delete of an unknown post: status %d, want 404", code)
+	}
+
+	// values with quotes used to break the hand-built JSON
+	var list struct {
+		Errors []handlers.RequestError `json:"errors"`
+	}
+	if code := do(t, "GET", api+`/posts/?sort="hot"`, "", nil, &list); code != http.StatusBadRequest || len(list.Errors) != 1 || list.Errors[0].Value != `"hot"` {
+		t.Errorf("unknown sort: status %d, errors %+v", code, list.Errors)
+	}
+
+	req, _ := http.NewRequest("GET", api+"/post/unknown", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /post/unknown: %v", err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusNotFound || ct != "application/json" {
+		t.Errorf("unknown post: status %d, content type %q", resp.StatusCode, ct)
+	}
+
+	req.Header.Set("Accept", handlers.ProblemContentType)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /post/unknown: %v", err)
+	}
+	defer resp.Body.Close()
+	var problem struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Detail string `json:"detail"`
+	}
+	json.NewDecoder(resp.Body).Decode(&problem)
+	if ct := resp.Header.Get("Content-Type"); ct != handlers.ProblemContentType {
+		t.Errorf("problem content type %q", ct)
+	}
+	if problem.Status != http.StatusNotFound || problem.Title != "Not Found" || problem.Detail != storage.ErrPostNotFound.Error() {
+		t.Errorf("problem document %+v", problem)
+	}
+
+	// requests no route matches get JSON errors too
+	unmatched := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{"GET", "/nope", http.StatusNotFound, ""},
+		{"GET", "/post/x/y/z/w", http.StatusNotFound, ""},
+		{"PUT", "/posts/", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"POST", "/communities", http.StatusUnauthorized, ""},
+		{"DELETE", "/communities", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+	}
+	for _, c := range unmatched {
+		for _, accept := range []string{"", handlers.ProblemContentType} {
+			req, _ := http.NewRequest(c.method, api+c.path, nil)
+			req.Header.Set("Accept", accept)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.method, c.path, err)
+			}
+			var body struct {
+				Message string `json:"message"`
+				Status  int    `json:"status"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&body)
+			resp.Body.Close()
+
+			wantType := "application/json"
+			if accept != "" {
+				wantType = handlers.ProblemContentType
+			}
+			if err != nil || resp.StatusCode != c.status || resp.Header.Get("Content-Type") != wantType {
+				t.Errorf("%s %s accepting %q: status %d, content type %q, decode error %v, want %d and %s",
+					c.method, c.path, accept, resp.StatusCode, resp.Header.Get("Content-Type"), err, c.status, wantType)
+			}
+			if accept != "" && body.Status != c.status || accept == "" && body.Message == "" {
+				t.Errorf("%s %s accepting %q: body %+v", c.method, c.path, accept, body)
+			}
+			if allow := resp.Header.Get("Allow"); allow != c.allow {
+				t.Errorf("%s %s: Allow %q, want %q", c.method, c.path, allow, c.allow)
+			}
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	_, api := startService(t, testConfig(t))
+
+	req, _ := http.NewRequest("GET", api+"/communities", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /communities: %v", err)
+	}
+	resp.Body.Close()
+	generated := resp.Header.Get(handlers.RequestIDHeader)
+	if generated == "" {
+		t.Error("no request ID generated")
+	}
+
+	req.Header.Set(handlers.RequestIDHeader, "edge-1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /communities: %v", err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(handlers.RequestIDHeader); id != "edge-1234" {
+		t.Errorf("request ID %q, want the incoming edge-1234", id)
+	}
+
+	req.Header.Set(handlers.RequestIDHeader, "forged entry")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /communities: %v", err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(handlers.RequestIDHeader); id == "" || id == generated || strings.Contains(id, " ") {
+		t.Errorf("request ID %q, want a new one for an invalid incoming ID", id)
+	}
+}
+
+func scrapeMetrics(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	cfg := testConfig(t)
+	s, api := startService(t, cfg)
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	do(t, "POST", api+"/register", "", map[string]string{"username": "alice", "password": testPassword}, &auth)
+	do(t, "POST", api+"/login", "", map[string]string{"username": "alice", "password": "wrong-password"}, nil)
+	var post storage.Post
+	do(t, "POST", api+"/posts", auth.Token, map[string]string{"type": "text", "category": "news", "title": "hello", "text": "world"}, &post)
+	do(t, "GET", api+"/post/"+post.ID+"/downvote", auth.Token, nil, nil)
+	do(t, "GET", api+"/no/such/route", "", nil, nil)
+
+	body := scrapeMetrics(t, fmt.Sprintf("http://%s/metrics", s.Addr()))
+	for _, line := range []string{
+		`redditclone_http_requests_total{method="POST",route="/api/posts",status="201"} 1`,
+		`redditclone_http_requests_total{method="GET",route="/api/post/{id}/downvote",status="200"} 1`,
+		`redditclone_http_requests_total{method="",route="unmatched",status="404"} 1`,
+		`redditclone_registrations_total 1`,
+		`redditclone_logins_total{result="failure"} 1`,
+		`redditclone_posts_created_total 1`,
+		`redditclone_votes_total{direction="down"} 1`,
+		`redditclone_storage_users 1`,
+		`redditclone_storage_posts 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+
+	// on an admin listener the metrics leave the API address
+	cfg = testConfig(t)
+	cfg.Metrics.Addr = "127.0.0.1:0"
+	s, _ = startService(t, cfg)
+	scrapeMetrics(t, fmt.Sprintf("http://%s/metrics", s.AdminAddr()))
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", s.Addr()))
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("metrics on the API address: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Tracing.Exporter = config.TraceFile
+	cfg.Tracing.File = filepath.Join(t.TempDir(), "traces.jsonl")
+	s, api := startService(t, cfg)
+
+	do(t, "POST", api+"/register", "", map[string]string{"username": "alice", "password": testPassword}, nil)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	body, _ := json.Marshal(map[string]string{"username": "alice", "password": testPassword})
+	req, _ := http.NewRequest("POST", api+"/login", bytes.NewReader(body))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /login: %v", err)
+	}
+	resp.Body.Close()
+
+	if code := do(t, "GET", fmt.Sprintf("http://%s/metrics", s.Addr()), "", nil, nil); code != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", code)
+	}
+
+	// shutting down flushes the spans
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.Tracing.File)
+	if err != nil {
+		t.Fatalf("read traces: %v", err)
+	}
+	names, all := map[string]bool{}, map[string]bool{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var span struct {
+			Name        string
+			SpanContext struct{ TraceID string }
+		}
+		err = dec.Decode(&span)
+		if err != nil {
+			t.Fatalf("decode span: %v", err)
+		}
+		all[span.Name] = true
+		if span.SpanContext.TraceID == traceID {
+			names[span.Name] = true
+		}
+	}
+	for _, name := range []string{"POST /api/login", "storage.GetUser"} {
+		if !names[name] {
+			t.Errorf("trace %s lacks span %s, has %v", traceID, name, names)
+		}
+	}
+	// scrapes read the storage without tracing it
+	if all["storage.CountUsers"] {
+		t.Error("metrics scrape traced its storage calls")
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"redditclone/internal/authz"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEntry records a privileged action.
+type AuditEntry struct {
+	ID          string     `json:"id"`
+	CreatedTime string     `json:"created"`
+	Actor       PostAuthor `json:"actor"`
+	// Role is what allowed the action: an admin or a moderator role.
+	Role      string       `json:"role"`
+	Action    authz.Action `json:"action"`
+	Community string       `json:"community,omitempty"`
+	// Target is the id of the post or comment, or the name of the user
+	// the action was performed on.
+	Target  string `json:"target"`
+	Details string `json:"details,omitempty"`
+}
+
+type AuditStorage interface {
+	// AddAuditEntry stores an entry, its id and time are set by the storage.
+	AddAuditEntry(entry AuditEntry) (AuditEntry, error)
+	// GetAuditLog returns all entries, the newest first.
+	GetAuditLog() []AuditEntry
+}
+
+type AuditInMemStorage struct {
+	entries []AuditEntry
+	mu      *sync.RWMutex
+}
+
+func NewAuditInMemStorage() *AuditInMemStorage {
+	return &AuditInMemStorage{mu: &sync.RWMutex{}}
+}
+
+func (s *AuditInMemStorage) AddAuditEntry(entry AuditEntry) (AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = uuid.NewString()
+	entry.CreatedTime = time.Now().Format(time.RFC3339)
+	s.entries = append(s.entries, entry)
+	return entry, nil
+}
+
+func (s *AuditInMemStorage) GetAuditLog() []AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := slices.Clone(s.entries)
+	slices.Reverse(entries)
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return entries
+}
+
+// putAuditEntry appends an entry as is, getAuditEntries returns all
+// entries in the order they were added.
+func (s *AuditInMemStorage) putAuditEntry(entry AuditEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entry)
+}
+
+func (s *AuditInMemStorage) getAuditEntries() []AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.entries)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+type BanKind string
+
+const (
+	BanNone BanKind = ""
+	// BanPermanent locks the user out until the ban is lifted.
+	BanPermanent BanKind = "ban"
+	// BanSuspended lets the user read but not write until the ban ends.
+	BanSuspended BanKind = "suspension"
+	// BanShadow hides everything the user posts from everyone else, the
+	// user is not told.
+	BanShadow BanKind = "shadowban"
+)
+
+var (
+	ErrUnknownBan   = errors.New("unknown ban kind")
+	ErrInvalidUntil = errors.New("a suspension has to end in the future")
+)
+
+// Ban is a restriction of a user by an admin. The zero Ban means the user
+// is not restricted.
+type Ban struct {
+	Kind   BanKind `json:"kind"`
+	Reason string  `json:"reason,omitempty"`
+	// Until is the end of a suspension, bans and shadowbans last until
+	// they are lifted.
+	Until       time.Time  `json:"until,omitzero"`
+	Moderator   PostAuthor `json:"moderator"`
+	CreatedTime string     `json:"created"`
+}
+
+// Effective returns the kind of the ban at the given time: a suspension
+// that has ended is no ban.
+func (b Ban) Effective(now time.Time) BanKind {
+	if b.Kind == BanSuspended && !now.Before(b.Until) {
+		return BanNone
+	}
+	return b.Kind
+}
+
+func (b Ban) validate(now time.Time) error {
+	switch b.Kind {
+	case BanNone, BanPermanent, BanShadow:
+		return nil
+	case BanSuspended:
+		if !b.Until.After(now) {
+			return ErrInvalidUntil
+		}
+		return nil
+	default:
+		return ErrUnknownBan
+	}
+}
+
+// SetUserBan restricts the user, a ban of kind BanNone lifts the current one.
+func (s *UserInMemStorage) SetUserBan(name string, ban Ban) (User, error) {
+	now := time.Now()
+	err := ban.validate(now)
+	if err != nil {
+		return User{}, err
+	}
+	if ban.Kind == BanNone {
+		ban = Ban{}
+	} else if ban.CreatedTime == "" {
+		ban.CreatedTime = now.Format(time.RFC3339)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+
+	user.Ban = ban
+	s.users[name] = user
+	s.indexBan(user)
+	return user, nil
+}
+
+// indexBan keeps the set of shadowbanned users up to date with the user.
+// The caller holds s.mu.
+func (s *UserInMemStorage) indexBan(user User) {
+	if user.Ban.Kind == BanShadow {
+		s.shadowbanned[user.Name] = true
+	} else {
+		delete(s.shadowbanned, user.Name)
+	}
+}
+
+func (s *UserInMemStorage) ShadowbannedUsers() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.shadowbanned))
+	for name := range s.shadowbanned {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// HideAuthors returns the post without the comments of the given users and
+// the replies to them.
+func HideAuthors(post Post, authors []string) Post {
+	if len(authors) == 0 {
+		return post
+	}
+
+	// comments are stored in the order they were posted, so parents are
+	// seen before their replies
+	hidden := map[string]bool{}
+	comments := make([]Comment, 0, len(post.Comments))
+	for _, c := range post.Comments {
+		if hidden[c.ParentID] || slices.Contains(authors, c.Author.Name) {
+			hidden[c.ID] = true
+			continue
+		}
+		comments = append(comments, c)
+	}
+
+	post.Comments = comments
+	return post
+}
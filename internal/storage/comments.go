@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeletedText replaces the body and the author name of deleted comments.
+const DeletedText = "[deleted]"
+
+type CommentSort string
+
+const (
+	CommentBest          CommentSort = "best"
+	CommentTop           CommentSort = "top"
+	CommentNew           CommentSort = "new"
+	CommentOld           CommentSort = "old"
+	CommentControversial CommentSort = "controversial"
+)
+
+// CommentThread is a comment with all of its replies.
+type CommentThread struct {
+	Comment
+	Replies []CommentThread `json:"replies"`
+}
+
+func newComment(userID, username, message string) Comment {
+	return Comment{
+		ID:          uuid.NewString(),
+		Body:        message,
+		CreatedTime: time.Now().Format(time.RFC3339),
+		Author: PostAuthor{
+			Name: username,
+			ID:   userID,
+		},
+		Rating: newRating(userID),
+	}
+}
+
+func findComment(comments []Comment, commentID string) int {
+	return slices.IndexFunc(comments, func(c Comment) bool {
+		return c.ID == commentID
+	})
+}
+
+func hasReplies(comments []Comment, commentID string) bool {
+	return slices.ContainsFunc(comments, func(c Comment) bool {
+		return c.ParentID == commentID
+	})
+}
+
+// deleteComment deletes the i-th comment. It is replaced by a placeholder
+// while it has replies. Deleted ancestors left without replies are removed
+// as well.
+func deleteComment(comments []Comment, i int) []Comment {
+	comment := comments[i]
+	if hasReplies(comments, comment.ID) {
+		comments[i].Deleted = true
+		comments[i].Body = DeletedText
+		comments[i].Author = PostAuthor{Name: DeletedText}
+		return comments
+	}
+
+	comments = slices.Delete(comments, i, i+1)
+	if comment.ParentID == "" {
+		return comments
+	}
+
+	parent := findComment(comments, comment.ParentID)
+	if parent != -1 && comments[parent].Deleted && !hasReplies(comments, comment.ParentID) {
+		return deleteComment(comments, parent)
+	}
+
+	return comments
+}
+
+// commentOrder returns the comparison of the sort. Comments it considers
+// equal keep the order they were posted in, which is what new and old
+// sorts rely on.
+func commentOrder(sort CommentSort) (func(a, b Comment) int, error) {
+	byKey := func(key func(c Comment) float64) func(a, b Comment) int {
+		return func(a, b Comment) int {
+			return cmp.Compare(key(b), key(a))
+		}
+	}
+
+	switch sort {
+	case CommentBest, "":
+		return byKey(func(c Comment) float64 { return confidence(c.Votes) }), nil
+	case CommentTop:
+		return byKey(func(c Comment) float64 { return float64(c.Score) }), nil
+	case CommentNew, CommentOld:
+		return func(a, b Comment) int { return 0 }, nil
+	case CommentControversial:
+		return byKey(func(c Comment) float64 { return controversy(c.Votes) }), nil
+	default:
+		return nil, ErrUnknownSort
+	}
+}
+
+// BuildCommentThreads arranges a flat list of comments into threads.
+// Replies of every comment, as well as the top level comments, are
+// ordered by sort.
+func BuildCommentThreads(comments []Comment, sort CommentSort) ([]CommentThread, error) {
+	order, err := commentOrder(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	// comments are stored in the order they were posted
+	if sort == CommentNew {
+		comments = slices.Clone(comments)
+		slices.Reverse(comments)
+	}
+
+	children := map[string][]Comment{}
+	for _, c := range comments {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+
+	var build func(parentID string) []CommentThread
+	build = func(parentID string) []CommentThread {
+		replies := children[parentID]
+		slices.SortStableFunc(replies, order)
+
+		threads := make([]CommentThread, 0, len(replies))
+		for _, c := range replies {
+			threads = append(threads, CommentThread{
+				Comment: c.public(),
+				Replies: build(c.ID),
+			})
+		}
+		return threads
+	}
+
+	return build(""), nil
+}
+
+// hidden reports whether the comment was deleted by its author or removed
+// by a moderator.
+func (c Comment) hidden() bool {
+	return c.Deleted || c.Moderation.Removed()
+}
+
+// public returns the comment as it is shown to users: removed comments keep
+// their place in the thread without their body and author.
+func (c Comment) public() Comment {
+	if c.Moderation.Removed() {
+		c.Removed = true
+		c.Body = RemovedText
+		c.Author = PostAuthor{Name: RemovedText}
+	}
+	return c
+}
+
+func (s *PostInMemStorage) UpvoteComment(postID, commentID, userID string) (Post, error) {
+	return s.voteComment(postID, commentID, userID, UPVOTE)
+}
+
+func (s *PostInMemStorage) DownvoteComment(postID, commentID, userID string) (Post, error) {
+	return s.voteComment(postID, commentID, userID, DOWNVOTE)
+}
+
+func (s *PostInMemStorage) UnvoteComment(postID, commentID, userID string) (Post, error) {
+	return s.voteComment(postID, commentID, userID, NOVOTE)
+}
+
+func (s *PostInMemStorage) voteComment(postID, commentID, userID string, vote UpDownVote) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		i := findComment(post.Comments, commentID)
+		if i == -1 {
+			return ErrCommentNotFound
+		}
+		if post.Comments[i].hidden() {
+			return ErrCommentDeleted
+		}
+
+		post.Comments[i].vote(userID, vote)
+		return nil
+	})
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Community is a category posts are published in.
+type Community struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Rules       string     `json:"rules"`
+	CreatedTime string     `json:"created"`
+	Creator     PostAuthor `json:"creator"`
+	// PostTypes limits posts to the listed types, empty allows every type.
+	PostTypes []PostType `json:"postTypes"`
+	// Restricted communities only accept posts from their creator.
+	Restricted bool `json:"restricted"`
+	// Moderators can remove posts and comments of the community. The
+	// creator moderates the community without being listed.
+	Moderators []PostAuthor `json:"moderators"`
+}
+
+type CommunityStorage interface {
+	AddCommunity(community Community) (Community, error)
+	GetCommunity(name string) (Community, error)
+	GetCommunities() []Community
+	// AddModerator and RemoveModerator do nothing if the user already
+	// is or is not a moderator.
+	AddModerator(name string, moderator PostAuthor) (Community, error)
+	RemoveModerator(name, userID string) (Community, error)
+	// ModeratedCommunities returns the names of the communities the user
+	// created or moderates.
+	ModeratedCommunities(userID string) []string
+}
+
+type CommunityInMemStorage struct {
+	communities map[string]Community
+	mu          *sync.RWMutex
+}
+
+var (
+	ErrCommunityNotFound      = errors.New("community not found")
+	ErrCommunityAlreadyExists = errors.New("community already exists")
+	ErrPostingRestricted      = errors.New("only the creator can post in this community")
+	ErrPostTypeNotAllowed     = errors.New("post type is not allowed in this community")
+)
+
+func NewCommunityInMemStorage() *CommunityInMemStorage {
+	return &CommunityInMemStorage{map[string]Community{}, &sync.RWMutex{}}
+}
+
+// CanPost checks the posting restrictions of the community.
+func (c Community) CanPost(userID string, postType PostType) error {
+	if c.Restricted && c.Creator.ID != userID {
+		return ErrPostingRestricted
+	}
+	if len(c.PostTypes) != 0 && !slices.Contains(c.PostTypes, postType) {
+		return ErrPostTypeNotAllowed
+	}
+
+	return nil
+}
+
+func (c Community) clone() Community {
+	c.PostTypes = slices.Clone(c.PostTypes)
+	if c.PostTypes == nil {
+		c.PostTypes = []PostType{}
+	}
+	c.Moderators = slices.Clone(c.Moderators)
+	if c.Moderators == nil {
+		c.Moderators = []PostAuthor{}
+	}
+	return c
+}
+
+// Moderates reports whether the user created or moderates the community.
+func (c Community) Moderates(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	if c.Creator.ID == userID {
+		return true
+	}
+
+	return slices.ContainsFunc(c.Moderators, func(m PostAuthor) bool {
+		return m.ID == userID
+	})
+}
+
+// AddCommunity stores a new community. Its creation time is set by the storage.
+func (s *CommunityInMemStorage) AddCommunity(community Community) (Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.communities[community.Name]
+	if ok {
+		return Community{}, ErrCommunityAlreadyExists
+	}
+
+	community = community.clone()
+	community.CreatedTime = time.Now().Format(time.RFC3339)
+	s.communities[community.Name] = community
+	return community.clone(), nil
+}
+
+func (s *CommunityInMemStorage) GetCommunity(name string) (Community, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	community, ok := s.communities[name]
+	if !ok {
+		return Community{}, ErrCommunityNotFound
+	}
+
+	return community.clone(), nil
+}
+
+// GetCommunities returns all communities ordered by name.
+func (s *CommunityInMemStorage) GetCommunities() []Community {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	communities := make([]Community, 0, len(s.communities))
+	for _, c := range s.communities {
+		communities = append(communities, c.clone())
+	}
+
+	slices.SortFunc(communities, func(a, b Community) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return communities
+}
+
+func (s *CommunityInMemStorage) AddModerator(name string, moderator PostAuthor) (Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	community, ok := s.communities[name]
+	if !ok {
+		return Community{}, ErrCommunityNotFound
+	}
+
+	if !community.Moderates(moderator.ID) {
+		community = community.clone()
+		community.Moderators = append(community.Moderators, moderator)
+		s.communities[name] = community
+	}
+	return community.clone(), nil
+}
+
+func (s *CommunityInMemStorage) RemoveModerator(name, userID string) (Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	community, ok := s.communities[name]
+	if !ok {
+		return Community{}, ErrCommunityNotFound
+	}
+
+	community = community.clone()
+	community.Moderators = slices.DeleteFunc(community.Moderators, func(m PostAuthor) bool {
+		return m.ID == userID
+	})
+	s.communities[name] = community
+	return community.clone(), nil
+}
+
+func (s *CommunityInMemStorage) ModeratedCommunities(userID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	for name, c := range s.communities {
+		if c.Moderates(userID) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+func (s *CommunityInMemStorage) putCommunity(community Community) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.communities[community.Name] = community
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"redditclone/internal/authz"
+	"time"
+)
+
+// TitleEditPeriod is how long after posting the title of a post can be changed.
+const TitleEditPeriod = 5 * time.Minute
+
+var (
+	ErrTitleEditExpired = errors.New("title can not be changed anymore")
+	ErrNotTextPost      = errors.New("only text posts can be edited")
+)
+
+// Revision is a replaced version of a post or a comment. Title is only set
+// for posts. CreatedTime is when the version was written.
+type Revision struct {
+	Title       string `json:"title,omitempty"`
+	Body        string `json:"body"`
+	CreatedTime string `json:"created"`
+}
+
+// PostEdit lists the changes of a post, nil fields are left as they are.
+type PostEdit struct {
+	Title *string
+	Text  *string
+}
+
+// authorize checks that the actor may perform the action on a post or a
+// comment of the author in the community.
+func authorize(actor authz.Subject, action authz.Action, author PostAuthor, community string) error {
+	if !authz.Can(actor, action, authz.Resource{AuthorID: author.ID, Community: community}) {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// versionTime is when the current version of an item was written.
+func versionTime(createdTime, editedTime string) string {
+	if editedTime != "" {
+		return editedTime
+	}
+
+	return createdTime
+}
+
+func (s *PostInMemStorage) EditPost(postID string, actor authz.Subject, edit PostEdit) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		err := authorize(actor, authz.EditPost, post.Author, post.Category)
+		if err != nil {
+			return err
+		}
+
+		titleChanged := edit.Title != nil && *edit.Title != post.Title
+		textChanged := edit.Text != nil && *edit.Text != post.Content
+		if textChanged && post.Type != TEXT {
+			return ErrNotTextPost
+		}
+		if titleChanged && time.Since(createdTime(*post)) > TitleEditPeriod {
+			return ErrTitleEditExpired
+		}
+		if !titleChanged && !textChanged {
+			return nil
+		}
+
+		post.Revisions = append(post.Revisions, Revision{
+			Title:       post.Title,
+			Body:        post.Content,
+			CreatedTime: versionTime(post.CreatedTime, post.EditedTime),
+		})
+		if titleChanged {
+			post.Title = *edit.Title
+		}
+		if textChanged {
+			post.Content = *edit.Text
+		}
+		post.EditedTime = time.Now().Format(time.RFC3339)
+		return nil
+	})
+}
+
+func (s *PostInMemStorage) EditComment(postID, commentID string, actor authz.Subject, body string) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		i := findComment(post.Comments, commentID)
+		if i == -1 || post.Comments[i].hidden() {
+			return ErrCommentNotFound
+		}
+
+		comment := &post.Comments[i]
+		err := authorize(actor, authz.EditComment, comment.Author, post.Category)
+		if err != nil {
+			return err
+		}
+		if comment.Body == body {
+			return nil
+		}
+
+		comment.Revisions = append(comment.Revisions, Revision{
+			Body:        comment.Body,
+			CreatedTime: versionTime(comment.CreatedTime, comment.EditedTime),
+		})
+		comment.Body = body
+		comment.EditedTime = time.Now().Format(time.RFC3339)
+		return nil
+	})
+}
@@ -0,0 +1,482 @@
+package storage
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"redditclone/internal/authz"
+	"sync"
+	"time"
+)
+
+// FileStorage keeps all data in memory and appends every change to a journal
+// file. The journal is replayed on startup and compacted to a snapshot, so
+// the file only grows between restarts.
+type FileStorage struct {
+	InMemoryStorage
+	path string
+	file *os.File
+	enc  *gob.Encoder
+	// mu serializes changes, so the journal keeps them in the order
+	// they were applied. Reads go to the in-memory storage directly.
+	mu *sync.Mutex
+}
+
+// journalEntry is a single record of the journal. Exactly one field is set:
+// Post, User, Community and Session replace the stored entity, AuditEntry
+// is appended to the audit log, DeletedPost and DeletedSession remove an
+// entity, DeletedUserSessions all sessions of a user.
+type journalEntry struct {
+	Post                *Post
+	User                *User
+	Community           *Community
+	Session             *Session
+	AuditEntry          *AuditEntry
+	DeletedPost         string
+	DeletedSession      string
+	DeletedUserSessions string
+}
+
+func NewFileStorage(path string) (*FileStorage, error) {
+	s := &FileStorage{
+		InMemoryStorage: NewInMemStorage(),
+		path:            path,
+		mu:              &sync.Mutex{},
+	}
+
+	err := s.replay()
+	if err != nil {
+		return nil, fmt.Errorf("replay journal %s: %w", path, err)
+	}
+
+	err = s.compact()
+	if err != nil {
+		return nil, fmt.Errorf("compact journal %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *FileStorage) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := gob.NewDecoder(file)
+	for {
+		var entry journalEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// a torn record at the end is a write interrupted by a crash
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.apply(entry)
+	}
+}
+
+func (s *FileStorage) apply(entry journalEntry) {
+	switch {
+	case entry.Post != nil:
+		s.putPost(*entry.Post)
+	case entry.User != nil:
+		s.putUser(*entry.User)
+	case entry.Community != nil:
+		s.putCommunity(*entry.Community)
+	case entry.Session != nil:
+		s.putSession(*entry.Session)
+	case entry.AuditEntry != nil:
+		s.putAuditEntry(*entry.AuditEntry)
+	case entry.DeletedPost != "":
+		s.removePost(entry.DeletedPost)
+	case entry.DeletedSession != "":
+		s.removeSession(entry.DeletedSession)
+	case entry.DeletedUserSessions != "":
+		s.removeUserSessions(entry.DeletedUserSessions)
+	}
+}
+
+// compact rewrites the journal with the current state and leaves it open for appending.
+func (s *FileStorage) compact() error {
+	tmpPath := s.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(file)
+	for _, user := range s.getUsers() {
+		err = enc.Encode(journalEntry{User: &user})
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	for _, community := range s.GetCommunities() {
+		err = enc.Encode(journalEntry{Community: &community})
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	for _, post := range s.allPosts() {
+		err = enc.Encode(journalEntry{Post: &post})
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	for _, session := range s.getSessions() {
+		err = enc.Encode(journalEntry{Session: &session})
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	for _, entry := range s.getAuditEntries() {
+		err = enc.Encode(journalEntry{AuditEntry: &entry})
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.enc = enc
+	return nil
+}
+
+func (s *FileStorage) append(entry journalEntry) error {
+	err := s.enc.Encode(entry)
+	if err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileStorage) journalPost(post Post, err error) (Post, error) {
+	if err != nil {
+		return Post{}, err
+	}
+
+	return post, s.append(journalEntry{Post: &post})
+}
+
+func (s *FileStorage) journalCommunity(community Community, err error) (Community, error) {
+	if err != nil {
+		return Community{}, err
+	}
+
+	return community, s.append(journalEntry{Community: &community})
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.file.Sync()
+	if err != nil {
+		s.file.Close()
+		return err
+	}
+
+	return s.file.Close()
+}
+
+func (s *FileStorage) AddUser(name, password string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.InMemoryStorage.AddUser(name, password)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, s.append(journalEntry{User: &user})
+}
+
+func (s *FileStorage) SetUserRole(name string, role authz.Role) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.InMemoryStorage.SetUserRole(name, role)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, s.append(journalEntry{User: &user})
+}
+
+func (s *FileStorage) SetUserBan(name string, ban Ban) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.InMemoryStorage.SetUserBan(name, ban)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, s.append(journalEntry{User: &user})
+}
+
+func (s *FileStorage) AddCommunity(community Community) (Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	community, err := s.InMemoryStorage.AddCommunity(community)
+	if err != nil {
+		return Community{}, err
+	}
+
+	return community, s.append(journalEntry{Community: &community})
+}
+
+func (s *FileStorage) AddModerator(name string, moderator PostAuthor) (Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalCommunity(s.InMemoryStorage.AddModerator(name, moderator))
+}
+
+func (s *FileStorage) RemoveModerator(name, userID string) (Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalCommunity(s.InMemoryStorage.RemoveModerator(name, userID))
+}
+
+func (s *FileStorage) AddPost(rawPost RawPost, authorName string, authorID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.AddPost(rawPost, authorName, authorID))
+}
+
+func (s *FileStorage) DeletePost(postID string, actor authz.Subject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.InMemoryStorage.DeletePost(postID, actor)
+	if err != nil {
+		return err
+	}
+
+	return s.append(journalEntry{DeletedPost: postID})
+}
+
+func (s *FileStorage) UpvotePost(postID, userID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.UpvotePost(postID, userID))
+}
+
+func (s *FileStorage) DownvotePost(postID, userID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.DownvotePost(postID, userID))
+}
+
+func (s *FileStorage) UnvotePost(postID, userID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.UnvotePost(postID, userID))
+}
+
+func (s *FileStorage) AddComment(postID, userID, username, message string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.AddComment(postID, userID, username, message))
+}
+
+func (s *FileStorage) AddReply(postID, parentID, userID, username, message string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.AddReply(postID, parentID, userID, username, message))
+}
+
+func (s *FileStorage) UpvoteComment(postID, commentID, userID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.UpvoteComment(postID, commentID, userID))
+}
+
+func (s *FileStorage) DownvoteComment(postID, commentID, userID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.DownvoteComment(postID, commentID, userID))
+}
+
+func (s *FileStorage) UnvoteComment(postID, commentID, userID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.UnvoteComment(postID, commentID, userID))
+}
+
+func (s *FileStorage) DeleteComment(postID string, actor authz.Subject, commentID string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.DeleteComment(postID, actor, commentID))
+}
+
+func (s *FileStorage) EditPost(postID string, actor authz.Subject, edit PostEdit) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.EditPost(postID, actor, edit))
+}
+
+func (s *FileStorage) EditComment(postID, commentID string, actor authz.Subject, body string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.EditComment(postID, commentID, actor, body))
+}
+
+// AddViews journals the counted posts before it changes them in memory. A
+// failed write leaves memory untouched, so the views can be retried without
+// counting them twice; posts journalled before the failure are replaced by
+// the retry.
+func (s *FileStorage) AddViews(views map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for postID, n := range views {
+		// the in-memory storage skips the same posts
+		post, err := s.GetPost(postID)
+		if errors.Is(err, ErrPostNotFound) || err == nil && post.Moderation.Removed() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		post.Views += n
+		err = s.append(journalEntry{Post: &post})
+		if err != nil {
+			return err
+		}
+	}
+
+	// changes go through s.mu, so memory still holds the journalled counts
+	return s.InMemoryStorage.AddViews(views)
+}
+
+func (s *FileStorage) AddSession(userID, userName, refreshHash string, expiresAt time.Time) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.InMemoryStorage.AddSession(userID, userName, refreshHash, expiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, s.append(journalEntry{Session: &session})
+}
+
+func (s *FileStorage) RefreshSession(refreshHash, nextHash string, expiresAt time.Time) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.InMemoryStorage.RefreshSession(refreshHash, nextHash, expiresAt)
+	if err != nil {
+		return session, err
+	}
+
+	return session, s.append(journalEntry{Session: &session})
+}
+
+func (s *FileStorage) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.InMemoryStorage.DeleteSession(id)
+	if err != nil {
+		return err
+	}
+
+	return s.append(journalEntry{DeletedSession: id})
+}
+
+func (s *FileStorage) DeleteUserSessions(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.InMemoryStorage.DeleteUserSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.append(journalEntry{DeletedUserSessions: userID})
+}
+
+func (s *FileStorage) AddAuditEntry(entry AuditEntry) (AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.InMemoryStorage.AddAuditEntry(entry)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	return entry, s.append(journalEntry{AuditEntry: &entry})
+}
+
+func (s *FileStorage) ReportPost(postID string, report Report) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.ReportPost(postID, report))
+}
+
+func (s *FileStorage) ReportComment(postID, commentID string, report Report) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.ReportComment(postID, commentID, report))
+}
+
+func (s *FileStorage) ModeratePost(postID string, decision ModStatus, moderator PostAuthor) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.ModeratePost(postID, decision, moderator))
+}
+
+func (s *FileStorage) ModerateComment(postID, commentID string, decision ModStatus, moderator PostAuthor) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journalPost(s.InMemoryStorage.ModerateComment(postID, commentID, decision, moderator))
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type PostSort string
+
+const (
+	SortNew PostSort = "new"
+	SortTop PostSort = "top"
+)
+
+// TimeWindow limits top and controversial listings to recent posts.
+type TimeWindow string
+
+const (
+	WindowHour  TimeWindow = "hour"
+	WindowDay   TimeWindow = "day"
+	WindowWeek  TimeWindow = "week"
+	WindowMonth TimeWindow = "month"
+	WindowYear  TimeWindow = "year"
+	WindowAll   TimeWindow = "all"
+)
+
+var windows = map[TimeWindow]time.Duration{
+	WindowHour:  time.Hour,
+	WindowDay:   24 * time.Hour,
+	WindowWeek:  7 * 24 * time.Hour,
+	WindowMonth: 30 * 24 * time.Hour,
+	WindowYear:  365 * 24 * time.Hour,
+	WindowAll:   0,
+	"":          0,
+}
+
+const (
+	DefaultPostLimit = 25
+	MaxPostLimit     = 100
+)
+
+var (
+	ErrUnknownSort   = errors.New("unknown sort")
+	ErrUnknownWindow = errors.New("unknown time window")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// PostQuery selects a page of posts. Empty filters match every post.
+// After and Before are cursors taken from a previous PostPage, at most one
+// of them may be set. Window only applies to top and controversial sorts.
+type PostQuery struct {
+	Category string
+	Author   string
+	// HiddenAuthors are users whose posts are left out.
+	HiddenAuthors []string
+	Sort          PostSort
+	Window        TimeWindow
+	Limit         int
+	After         string
+	Before        string
+}
+
+// PostPage is a page of posts with cursors of the neighbouring pages.
+// A cursor is empty when there is no page in that direction.
+type PostPage struct {
+	Posts  []Post
+	After  string
+	Before string
+}
+
+// cursor is a position in a sorted listing: posts are ordered by rank
+// descending, ties are broken by ID.
+type cursor struct {
+	rank float64
+	id   string
+}
+
+func (c cursor) String() string {
+	raw := strconv.FormatFloat(c.rank, 'g', -1, 64) + "," + c.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	rankStr, id, ok := strings.Cut(string(raw), ",")
+	if !ok || id == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	rank, err := strconv.ParseFloat(rankStr, 64)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return cursor{rank, id}, nil
+}
+
+func (c cursor) compare(other cursor) int {
+	switch {
+	case c.rank > other.rank:
+		return -1
+	case c.rank < other.rank:
+		return 1
+	default:
+		return strings.Compare(c.id, other.id)
+	}
+}
+
+// rankedPost is an entry of a ranking index. It keeps only the fields
+// listings are filtered by, posts themselves are loaded per page.
+type rankedPost struct {
+	cursor
+	category string
+	author   string
+	created  time.Time
+}
+
+// rankPosts builds the ranking index of posts for the given sort.
+func rankPosts(posts []Post, r Ranker, now time.Time) []rankedPost {
+	index := make([]rankedPost, 0, len(posts))
+	for _, p := range posts {
+		rank := r.Rank(p, now)
+		if math.IsInf(rank, -1) || math.IsNaN(rank) {
+			continue
+		}
+
+		index = append(index, rankedPost{
+			cursor:   cursor{rank, p.ID},
+			category: p.Category,
+			author:   p.Author.Name,
+			created:  createdTime(p),
+		})
+	}
+
+	slices.SortFunc(index, func(a, b rankedPost) int {
+		return a.compare(b.cursor)
+	})
+
+	return index
+}
+
+// pageOf selects the IDs of the posts on the requested page of the index.
+func pageOf(index []rankedPost, q PostQuery, now time.Time) (ids []string, after, before string, err error) {
+	window, ok := windows[q.Window]
+	if !ok {
+		return nil, "", "", ErrUnknownWindow
+	}
+	if q.Sort != SortTop && q.Sort != SortControversial {
+		window = 0
+	}
+
+	var afterCursor, beforeCursor *cursor
+	if q.After != "" && q.Before != "" {
+		return nil, "", "", ErrInvalidCursor
+	}
+	if q.After != "" {
+		c, err := parseCursor(q.After)
+		if err != nil {
+			return nil, "", "", err
+		}
+		afterCursor = &c
+	}
+	if q.Before != "" {
+		c, err := parseCursor(q.Before)
+		if err != nil {
+			return nil, "", "", err
+		}
+		beforeCursor = &c
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPostLimit
+	}
+	limit = min(limit, MaxPostLimit)
+
+	matches := make([]rankedPost, 0, len(index))
+	for _, p := range index {
+		if q.Category != "" && p.category != q.Category {
+			continue
+		}
+		if q.Author != "" && p.author != q.Author {
+			continue
+		}
+		if slices.Contains(q.HiddenAuthors, p.author) {
+			continue
+		}
+		if window != 0 && now.Sub(p.created) > window {
+			continue
+		}
+		matches = append(matches, p)
+	}
+
+	search := func(c cursor) (int, bool) {
+		return slices.BinarySearchFunc(matches, c, func(p rankedPost, c cursor) int {
+			return p.compare(c)
+		})
+	}
+
+	// [start, end) is the window of the page within the matches
+	start, end := 0, len(matches)
+	if afterCursor != nil {
+		var found bool
+		start, found = search(*afterCursor)
+		if found {
+			start++
+		}
+		end = min(start+limit, len(matches))
+	} else if beforeCursor != nil {
+		end, _ = search(*beforeCursor)
+		start = max(end-limit, 0)
+	} else {
+		end = min(limit, len(matches))
+	}
+
+	for _, p := range matches[start:end] {
+		ids = append(ids, p.id)
+	}
+	if end < len(matches) && end > start {
+		after = matches[end-1].cursor.String()
+	}
+	if start > 0 && end > start {
+		before = matches[start].cursor.String()
+	}
+
+	return ids, after, before, nil
+}
+
+// rankCache keeps ranking indexes between requests. Adding or removing
+// posts drops them at once, votes change the order after ttl at most.
+type rankCache struct {
+	ttl time.Duration
+	// gen is bumped whenever the set of posts changes
+	gen     atomic.Uint64
+	mu      sync.Mutex
+	entries map[PostSort]rankCacheEntry
+}
+
+type rankCacheEntry struct {
+	gen   uint64
+	built time.Time
+	index []rankedPost
+}
+
+// DefaultRankCacheTTL is how long cached rankings may ignore new votes.
+const DefaultRankCacheTTL = 5 * time.Second
+
+func newRankCache(ttl time.Duration) *rankCache {
+	return &rankCache{ttl: ttl, entries: map[PostSort]rankCacheEntry{}}
+}
+
+func (c *rankCache) invalidate() {
+	c.gen.Add(1)
+}
+
+// index returns the cached ranking index for the sort, building it from
+// load when it is missing or stale. Concurrent callers wait for one build.
+func (c *rankCache) index(sort PostSort, now time.Time, load func() []Post) ([]rankedPost, error) {
+	r, err := ranker(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gen := c.gen.Load()
+	entry, ok := c.entries[sort]
+	if ok && entry.gen == gen && now.Sub(entry.built) < c.ttl {
+		return entry.index, nil
+	}
+
+	index := rankPosts(load(), r, now)
+	c.entries[sort] = rankCacheEntry{gen, now, index}
+	return index, nil
+}
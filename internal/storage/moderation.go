@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RemovedText replaces the body and the author name of removed comments.
+const RemovedText = "[removed]"
+
+// ModStatus is the moderation state of a post or a comment.
+type ModStatus string
+
+const (
+	// ModNone items were never reported.
+	ModNone ModStatus = ""
+	// ModOpen items wait for a moderator in the queue.
+	ModOpen      ModStatus = "open"
+	ModApproved  ModStatus = "approved"
+	ModRemoved   ModStatus = "removed"
+	ModDismissed ModStatus = "dismissed"
+)
+
+var ErrUnknownDecision = errors.New("unknown moderation decision")
+
+type Report struct {
+	UserID      string `json:"user"`
+	Reason      string `json:"reason"`
+	CreatedTime string `json:"created"`
+}
+
+// Moderation holds the reports of a post or a comment and the last
+// decision of a moderator. Decisions can be changed at any time, so a
+// removal is undone by approving the item.
+type Moderation struct {
+	Status      ModStatus   `json:"status"`
+	Reports     []Report    `json:"reports"`
+	Moderator   *PostAuthor `json:"moderator,omitempty"`
+	DecidedTime string      `json:"decided,omitempty"`
+}
+
+// QueueItem is a post or, if CommentID is set, a comment in the
+// moderation queue. Body is the text or url of a post.
+type QueueItem struct {
+	PostID    string     `json:"post"`
+	CommentID string     `json:"comment,omitempty"`
+	Community string     `json:"community"`
+	Author    PostAuthor `json:"author"`
+	Title     string     `json:"title,omitempty"`
+	Body      string     `json:"body"`
+	Moderation
+}
+
+type ModQueueQuery struct {
+	// Communities limits the queue to these communities, nil means all.
+	Communities []string
+	// Status selects the items, ModOpen by default.
+	Status ModStatus
+}
+
+type ModerationStorage interface {
+	// ReportPost and ReportComment put an item into the queue. Every user
+	// reports an item once, later reports of the same user are ignored.
+	ReportPost(postID string, report Report) (Post, error)
+	ReportComment(postID, commentID string, report Report) (Post, error)
+	// ModeratePost and ModerateComment decide on an item. The decision is
+	// ModApproved, ModRemoved or ModDismissed.
+	ModeratePost(postID string, decision ModStatus, moderator PostAuthor) (Post, error)
+	ModerateComment(postID, commentID string, decision ModStatus, moderator PostAuthor) (Post, error)
+	// GetModQueue returns the matching items, the most reported first.
+	GetModQueue(query ModQueueQuery) []QueueItem
+}
+
+func (m Moderation) Removed() bool {
+	return m.Status == ModRemoved
+}
+
+func (m Moderation) clone() Moderation {
+	m.Reports = slices.Clone(m.Reports)
+	if m.Reports == nil {
+		m.Reports = []Report{}
+	}
+	if m.Moderator != nil {
+		moderator := *m.Moderator
+		m.Moderator = &moderator
+	}
+	return m
+}
+
+// report adds a report and reopens items a moderator has kept. Removed
+// items stay removed.
+func (m *Moderation) report(report Report) {
+	reported := slices.ContainsFunc(m.Reports, func(r Report) bool {
+		return r.UserID == report.UserID
+	})
+	if reported {
+		return
+	}
+
+	report.CreatedTime = time.Now().Format(time.RFC3339)
+	m.Reports = append(m.Reports, report)
+	if !m.Removed() {
+		m.Status = ModOpen
+	}
+}
+
+func (m *Moderation) decide(decision ModStatus, moderator PostAuthor) error {
+	switch decision {
+	case ModApproved, ModRemoved, ModDismissed:
+	default:
+		return ErrUnknownDecision
+	}
+
+	m.Status = decision
+	m.Moderator = &moderator
+	m.DecidedTime = time.Now().Format(time.RFC3339)
+	return nil
+}
+
+func (s *PostInMemStorage) ReportPost(postID string, report Report) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		post.Moderation.report(report)
+		return nil
+	})
+}
+
+func (s *PostInMemStorage) ReportComment(postID, commentID string, report Report) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		i := findComment(post.Comments, commentID)
+		if i == -1 {
+			return ErrCommentNotFound
+		}
+		if post.Comments[i].hidden() {
+			return ErrCommentDeleted
+		}
+
+		post.Comments[i].Moderation.report(report)
+		return nil
+	})
+}
+
+func (s *PostInMemStorage) ModeratePost(postID string, decision ModStatus, moderator PostAuthor) (Post, error) {
+	post, err := s.updateEntry(postID, true, func(post *Post) error {
+		return post.Moderation.decide(decision, moderator)
+	})
+	if err != nil {
+		return Post{}, err
+	}
+
+	// removed posts leave and approved ones return to the listings
+	s.ranks.invalidate()
+	return post, nil
+}
+
+// ModerateComment decides on a comment, also under a removed post, which
+// may still be restored.
+func (s *PostInMemStorage) ModerateComment(postID, commentID string, decision ModStatus, moderator PostAuthor) (Post, error) {
+	return s.updateEntry(postID, true, func(post *Post) error {
+		i := findComment(post.Comments, commentID)
+		if i == -1 || post.Comments[i].Deleted {
+			return ErrCommentNotFound
+		}
+
+		return post.Comments[i].Moderation.decide(decision, moderator)
+	})
+}
+
+func (s *PostInMemStorage) GetModQueue(query ModQueueQuery) []QueueItem {
+	if query.Status == ModNone {
+		query.Status = ModOpen
+	}
+
+	items := []QueueItem{}
+	for _, post := range s.allPosts() {
+		if query.Communities != nil && !slices.Contains(query.Communities, post.Category) {
+			continue
+		}
+
+		if post.Moderation.Status == query.Status {
+			items = append(items, QueueItem{
+				PostID:     post.ID,
+				Community:  post.Category,
+				Author:     post.Author,
+				Title:      post.Title,
+				Body:       post.Content,
+				Moderation: post.Moderation,
+			})
+		}
+		for _, c := range post.Comments {
+			if c.Moderation.Status == query.Status && !c.Deleted {
+				items = append(items, QueueItem{
+					PostID:     post.ID,
+					CommentID:  c.ID,
+					Community:  post.Category,
+					Author:     c.Author,
+					Body:       c.Body,
+					Moderation: c.Moderation,
+				})
+			}
+		}
+	}
+
+	slices.SortFunc(items, func(a, b QueueItem) int {
+		if n := len(b.Reports) - len(a.Reports); n != 0 {
+			return n
+		}
+		if n := strings.Compare(a.PostID, b.PostID); n != 0 {
+			return n
+		}
+		return strings.Compare(a.CommentID, b.CommentID)
+	})
+	return items
+}
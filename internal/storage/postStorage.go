@@ -3,6 +3,7 @@ package storage
 import (
 	"encoding/json"
 	"errors"
+	"redditclone/internal/authz"
 	"slices"
 	"sync"
 	"time"
@@ -37,11 +38,24 @@ type PostAuthor struct {
 	ID   string `json:"id"`
 }
 
+// Comment is a comment on a post or a reply to another comment. Comments of
+// a post are stored as a flat list, ParentID and Depth describe the tree.
 type Comment struct {
 	ID          string     `json:"id"`
+	ParentID    string     `json:"parent,omitempty"`
+	Depth       int        `json:"depth"`
 	Body        string     `json:"body"`
 	CreatedTime string     `json:"created"`
 	Author      PostAuthor `json:"author"`
+	Rating
+	// Deleted comments stay in place while they have replies.
+	Deleted bool `json:"deleted,omitempty"`
+	// Removed is only set on the public copy of a comment a moderator
+	// removed, see Moderation.
+	Removed    bool       `json:"removed,omitempty"`
+	EditedTime string     `json:"edited,omitempty"`
+	Revisions  []Revision `json:"-"`
+	Moderation Moderation `json:"-"`
 }
 
 type Vote struct {
@@ -51,44 +65,75 @@ type Vote struct {
 
 type Post struct {
 	RawPost
-	ID               string     `json:"id"`
-	Author           PostAuthor `json:"author"`
-	Score            int        `json:"score"`
-	Views            int        `json:"views"`
-	CreatedTime      string     `json:"created"`
-	UpvotePercentage int        `json:"upvotePercentage"`
-	Votes            []Vote     `json:"votes"`
-	Comments         []Comment  `json:"comments"`
+	ID     string     `json:"id"`
+	Author PostAuthor `json:"author"`
+	Rating
+	Views       int        `json:"views"`
+	CreatedTime string     `json:"created"`
+	EditedTime  string     `json:"edited,omitempty"`
+	Comments    []Comment  `json:"comments"`
+	Revisions   []Revision `json:"-"`
+	// Moderation of removed posts hides them from listings.
+	Moderation Moderation `json:"-"`
 }
 
 type PostStorage interface {
-	AddPost(rawPost RawPost, authorName string, authorID string) Post
-	DeletePost(postID, userID string) error
+	AddPost(rawPost RawPost, authorName string, authorID string) (Post, error)
+	// DeletePost, DeleteComment, EditPost and EditComment return
+	// ErrPermissionDenied unless the actor is allowed to by authz.
+	DeletePost(postID string, actor authz.Subject) error
 	GetPosts() []Post
+	ListPosts(query PostQuery) (PostPage, error)
 	GetPost(id string) (Post, error)
 	UpvotePost(postID, userID string) (Post, error)
 	DownvotePost(postID, userID string) (Post, error)
 	UnvotePost(postID, userID string) (Post, error)
 	AddComment(postID, userID, username, message string) (Post, error)
-	DeleteComment(postID, userID, commentID string) (Post, error)
+	AddReply(postID, parentID, userID, username, message string) (Post, error)
+	UpvoteComment(postID, commentID, userID string) (Post, error)
+	DownvoteComment(postID, commentID, userID string) (Post, error)
+	UnvoteComment(postID, commentID, userID string) (Post, error)
+	DeleteComment(postID string, actor authz.Subject, commentID string) (Post, error)
+	EditPost(postID string, actor authz.Subject, edit PostEdit) (Post, error)
+	EditComment(postID, commentID string, actor authz.Subject, body string) (Post, error)
+	AddViews(views map[string]int) error
 }
 
+// PostInMemStorage locks every post separately, so requests to different
+// posts never wait for each other. mu only guards the posts map itself:
+// it is never held while a post lock is taken or the other way round.
 type PostInMemStorage struct {
-	posts map[string]Post
+	posts map[string]*postEntry
 	mu    *sync.RWMutex
+	ranks *rankCache
+}
+
+type postEntry struct {
+	mu   sync.RWMutex
+	post Post
+	// deleted is set under mu before the entry is removed from the map,
+	// so changes that already found the entry do not resurrect the post.
+	deleted bool
 }
 
 var (
 	ErrPostNotFound     = errors.New("post with given id not found")
 	ErrCommentNotFound  = errors.New("comment with given id not found")
+	ErrCommentDeleted   = errors.New("comment is deleted")
 	ErrPermissionDenied = errors.New("permission denied")
 )
 
 func NewPostInMemStorage() *PostInMemStorage {
-	return &PostInMemStorage{map[string]Post{}, &sync.RWMutex{}}
+	return &PostInMemStorage{map[string]*postEntry{}, &sync.RWMutex{}, newRankCache(DefaultRankCacheTTL)}
 }
 
 func (p Post) MarshalJSON() ([]byte, error) {
+	comments := make([]Comment, len(p.Comments))
+	for i, c := range p.Comments {
+		comments[i] = c.public()
+	}
+	p.Comments = comments
+
 	type Alias Post
 	aux := struct {
 		*Alias
@@ -141,7 +186,71 @@ func (p *Post) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (s *PostInMemStorage) AddPost(rawPost RawPost, authorName string, authorID string) Post {
+// clone returns a copy of the post that shares no memory with the original.
+// Lists of the copy are never nil, so they are encoded as empty JSON arrays.
+func (p Post) clone() Post {
+	p.Rating = p.Rating.clone()
+	p.Revisions = slices.Clone(p.Revisions)
+	p.Moderation = p.Moderation.clone()
+	comments := make([]Comment, len(p.Comments))
+	for i, c := range p.Comments {
+		c.Rating = c.Rating.clone()
+		c.Revisions = slices.Clone(c.Revisions)
+		c.Moderation = c.Moderation.clone()
+		comments[i] = c
+	}
+	p.Comments = comments
+	return p
+}
+
+func (s *PostInMemStorage) entry(postID string) (*postEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.posts[postID]
+	return entry, ok
+}
+
+func (s *PostInMemStorage) entries() []*postEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]*postEntry, 0, len(s.posts))
+	for _, e := range s.posts {
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// update applies change to the stored post atomically. change must leave the
+// post untouched when it returns an error. Removed posts are not found.
+func (s *PostInMemStorage) update(postID string, change func(post *Post) error) (Post, error) {
+	return s.updateEntry(postID, false, change)
+}
+
+func (s *PostInMemStorage) updateEntry(postID string, withRemoved bool, change func(post *Post) error) (Post, error) {
+	entry, ok := s.entry(postID)
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.deleted || entry.post.Moderation.Removed() && !withRemoved {
+		return Post{}, ErrPostNotFound
+	}
+
+	err := change(&entry.post)
+	if err != nil {
+		return Post{}, err
+	}
+
+	return entry.post.clone(), nil
+}
+
+func (s *PostInMemStorage) AddPost(rawPost RawPost, authorName string, authorID string) (Post, error) {
 	post := Post{}
 	post.Type = rawPost.Type
 	post.Category = rawPost.Category
@@ -152,192 +261,211 @@ func (s *PostInMemStorage) AddPost(rawPost RawPost, authorName string, authorID
 		Name: authorName,
 		ID:   authorID,
 	}
-	post.Score = 1
+	post.Rating = newRating(authorID)
 	post.Views = 1
 	post.CreatedTime = time.Now().Format(time.RFC3339)
-	post.UpvotePercentage = 100
-	post.Votes = []Vote{{UserID: authorID, Vote: UPVOTE}}
 	post.Comments = []Comment{}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.posts[post.ID] = post
+	s.putPost(post)
 
-	return post
+	return post.clone(), nil
 }
 
-func (s *PostInMemStorage) DeletePost(postID, userID string) error {
-	post, ok := s.posts[postID]
+func (s *PostInMemStorage) DeletePost(postID string, actor authz.Subject) error {
+	entry, ok := s.entry(postID)
 	if !ok {
 		return ErrPostNotFound
 	}
 
-	if post.Author.ID != userID {
-		return ErrPermissionDenied
+	entry.mu.Lock()
+	if entry.deleted {
+		entry.mu.Unlock()
+		return ErrPostNotFound
 	}
+	err := authorize(actor, authz.DeletePost, entry.post.Author, entry.post.Category)
+	if err != nil {
+		entry.mu.Unlock()
+		return err
+	}
+	entry.deleted = true
+	entry.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	delete(s.posts, postID)
+	s.ranks.invalidate()
 	return nil
 }
 
+// GetPosts returns all posts except the ones removed by moderators.
 func (s *PostInMemStorage) GetPosts() []Post {
-	posts := make([]Post, 0, len(s.posts))
-	for _, p := range s.posts {
-		posts = append(posts, p)
+	return slices.DeleteFunc(s.allPosts(), func(p Post) bool {
+		return p.Moderation.Removed()
+	})
+}
+
+func (s *PostInMemStorage) allPosts() []Post {
+	entries := s.entries()
+
+	posts := make([]Post, 0, len(entries))
+	for _, e := range entries {
+		e.mu.RLock()
+		if !e.deleted {
+			posts = append(posts, e.post.clone())
+		}
+		e.mu.RUnlock()
 	}
 
 	return posts
 }
 
-func (s *PostInMemStorage) GetPost(id string) (Post, error) {
-	post, ok := s.posts[id]
-	if !ok {
-		return Post{}, ErrPostNotFound
+func (s *PostInMemStorage) ListPosts(query PostQuery) (PostPage, error) {
+	if query.Sort == "" {
+		query.Sort = SortHot
 	}
 
-	return post, nil
-}
-
-func (s *PostInMemStorage) UpvotePost(postID, userID string) (Post, error) {
-	post, ok := s.posts[postID]
-	if !ok {
-		return Post{}, ErrPostNotFound
+	now := time.Now()
+	index, err := s.ranks.index(query.Sort, now, s.GetPosts)
+	if err != nil {
+		return PostPage{}, err
 	}
-	oldVote, found := updateVote(&post, userID, UPVOTE)
-	if found && oldVote == UPVOTE {
-		return post, nil
+
+	ids, after, before, err := pageOf(index, query, now)
+	if err != nil {
+		return PostPage{}, err
 	}
-	if found && oldVote == DOWNVOTE {
-		post.Score += 2
-	} else {
-		post.Score += 1
+
+	page := PostPage{Posts: make([]Post, 0, len(ids)), After: after, Before: before}
+	for _, id := range ids {
+		post, err := s.GetPost(id)
+		if errors.Is(err, ErrPostNotFound) || err == nil && post.Moderation.Removed() {
+			// deleted or removed after the ranking was built
+			continue
+		}
+		if err != nil {
+			return PostPage{}, err
+		}
+		page.Posts = append(page.Posts, post)
 	}
 
-	post.UpvotePercentage = countUpvotePercentage(post.Votes)
-	s.posts[postID] = post
-	return post, nil
+	return page, nil
 }
 
-func (s *PostInMemStorage) DownvotePost(postID, userID string) (Post, error) {
-	post, ok := s.posts[postID]
+func (s *PostInMemStorage) GetPost(id string) (Post, error) {
+	entry, ok := s.entry(id)
 	if !ok {
 		return Post{}, ErrPostNotFound
 	}
-	oldVote, found := updateVote(&post, userID, DOWNVOTE)
-	if found && oldVote == DOWNVOTE {
-		return post, nil
-	}
-	if found && oldVote == UPVOTE {
-		post.Score -= 2
-	} else {
-		post.Score -= 1
-	}
 
-	post.UpvotePercentage = countUpvotePercentage(post.Votes)
-	s.posts[postID] = post
-	return post, nil
-}
+	entry.mu.RLock()
+	defer entry.mu.RUnlock()
 
-func (s *PostInMemStorage) UnvotePost(postID, userID string) (Post, error) {
-	post, ok := s.posts[postID]
-	if !ok {
+	if entry.deleted {
 		return Post{}, ErrPostNotFound
 	}
-	oldVote, found := updateVote(&post, userID, NOVOTE)
-	if found && oldVote == UPVOTE {
-		post.Score -= 1
-	}
-	if found && oldVote == DOWNVOTE {
-		post.Score += 1
-	}
 
-	post.UpvotePercentage = countUpvotePercentage(post.Votes)
-	s.posts[postID] = post
-	return post, nil
-}
-
-func updateVote(post *Post, userID string, newVote UpDownVote) (oldVote UpDownVote, found bool) {
-	for i, vote := range post.Votes {
-		if vote.UserID == userID {
-			oldVote = vote.Vote
-			if i == len(post.Votes)-1 {
-				post.Votes = post.Votes[:len(post.Votes)-1]
-			} else {
-				post.Votes = slices.Delete(post.Votes, i, i+1)
-			}
-			found = true
-			break
+	return entry.post.clone(), nil
+}
+
+// AddViews adds view counts to posts. Posts that do not exist anymore are skipped.
+func (s *PostInMemStorage) AddViews(views map[string]int) error {
+	for postID, n := range views {
+		_, err := s.update(postID, func(post *Post) error {
+			post.Views += n
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrPostNotFound) {
+			return err
 		}
 	}
 
-	if newVote != 0 {
-		post.Votes = append(post.Votes, Vote{userID, newVote})
-	}
-	return
+	return nil
 }
 
-func countUpvotePercentage(votes []Vote) int {
-	if len(votes) == 0 {
-		return 0
-	}
+func (s *PostInMemStorage) UpvotePost(postID, userID string) (Post, error) {
+	return s.votePost(postID, userID, UPVOTE)
+}
 
-	upvotes := 0
-	for _, vote := range votes {
-		if vote.Vote == UPVOTE {
-			upvotes += 1
-		}
-	}
+func (s *PostInMemStorage) DownvotePost(postID, userID string) (Post, error) {
+	return s.votePost(postID, userID, DOWNVOTE)
+}
+
+func (s *PostInMemStorage) UnvotePost(postID, userID string) (Post, error) {
+	return s.votePost(postID, userID, NOVOTE)
+}
 
-	return int(float64(upvotes) / float64(len(votes)) * 100)
+func (s *PostInMemStorage) votePost(postID, userID string, vote UpDownVote) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		post.vote(userID, vote)
+		return nil
+	})
 }
 
 func (s *PostInMemStorage) AddComment(postID, userID, username, message string) (Post, error) {
-	post, ok := s.posts[postID]
-	if !ok {
-		return Post{}, ErrPostNotFound
-	}
+	return s.update(postID, func(post *Post) error {
+		post.Comments = append(post.Comments, newComment(userID, username, message))
+		return nil
+	})
+}
 
-	post.Comments = append(post.Comments, Comment{
-		ID:          uuid.NewString(),
-		Body:        message,
-		CreatedTime: time.Now().Format(time.RFC3339),
-		Author: PostAuthor{
-			Name: username,
-			ID:   userID,
-		},
+func (s *PostInMemStorage) AddReply(postID, parentID, userID, username, message string) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		i := findComment(post.Comments, parentID)
+		if i == -1 {
+			return ErrCommentNotFound
+		}
+		parent := post.Comments[i]
+		if parent.hidden() {
+			return ErrCommentDeleted
+		}
+
+		reply := newComment(userID, username, message)
+		reply.ParentID = parent.ID
+		reply.Depth = parent.Depth + 1
+		post.Comments = append(post.Comments, reply)
+		return nil
 	})
+}
+
+// DeleteComment removes a comment. A comment with replies is only marked as
+// deleted, so the replies keep their place in the thread.
+func (s *PostInMemStorage) DeleteComment(postID string, actor authz.Subject, commentID string) (Post, error) {
+	return s.update(postID, func(post *Post) error {
+		i := findComment(post.Comments, commentID)
+		if i == -1 || post.Comments[i].Deleted {
+			return ErrCommentNotFound
+		}
+		err := authorize(actor, authz.DeleteComment, post.Comments[i].Author, post.Category)
+		if err != nil {
+			return err
+		}
 
-	s.posts[postID] = post
-	return post, nil
+		post.Comments = deleteComment(post.Comments, i)
+		return nil
+	})
 }
 
-func (s *PostInMemStorage) DeleteComment(postID, userID, commentID string) (Post, error) {
-	post, ok := s.posts[postID]
-	if !ok {
-		return Post{}, ErrPostNotFound
-	}
+// putPost and removePost write a post as is, bypassing all checks.
+// They are used to restore the state saved by other backends.
+func (s *PostInMemStorage) putPost(post Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	found := false
-	for i, comment := range post.Comments {
-		if comment.ID == commentID {
-			if comment.Author.ID != userID {
-				return Post{}, ErrPermissionDenied
-			}
-
-			if i == len(post.Comments)-1 {
-				post.Comments = post.Comments[:len(post.Comments)-1]
-			} else {
-				post.Comments = slices.Delete(post.Comments, i, i+1)
-			}
-			found = true
-		}
-	}
+	s.posts[post.ID] = &postEntry{post: post.clone()}
+	s.ranks.invalidate()
+}
 
-	if !found {
-		return Post{}, ErrCommentNotFound
-	}
+func (s *PostInMemStorage) removePost(postID string) {
+	s.mu.Lock()
+	entry, ok := s.posts[postID]
+	delete(s.posts, postID)
+	s.ranks.invalidate()
+	s.mu.Unlock()
 
-	s.posts[postID] = post
-	return post, nil
+	if ok {
+		entry.mu.Lock()
+		entry.deleted = true
+		entry.mu.Unlock()
+	}
 }
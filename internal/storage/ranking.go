@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	SortHot           PostSort = "hot"
+	SortControversial PostSort = "controversial"
+	SortRising        PostSort = "rising"
+)
+
+// Ranker computes the rank of a post in a listing, posts with higher ranks
+// come first. now is the time the listing is built at. A rank of -Inf
+// leaves the post out of the listing.
+type Ranker interface {
+	Rank(post Post, now time.Time) float64
+}
+
+type RankerFunc func(post Post, now time.Time) float64
+
+func (f RankerFunc) Rank(post Post, now time.Time) float64 {
+	return f(post, now)
+}
+
+var rankers = map[PostSort]Ranker{
+	SortHot:           RankerFunc(hotRank),
+	SortTop:           RankerFunc(topRank),
+	SortNew:           RankerFunc(newRank),
+	SortControversial: RankerFunc(controversialRank),
+	SortRising:        RankerFunc(risingRank),
+}
+
+// RegisterRanker makes a ranker available as a listing sort. It is not
+// safe for concurrent use and is meant to be called during initialization.
+func RegisterRanker(sort PostSort, r Ranker) {
+	rankers[sort] = r
+}
+
+func ranker(sort PostSort) (Ranker, error) {
+	if sort == "" {
+		sort = SortHot
+	}
+
+	r, ok := rankers[sort]
+	if !ok {
+		return nil, ErrUnknownSort
+	}
+
+	return r, nil
+}
+
+func createdTime(post Post) time.Time {
+	created, _ := time.Parse(time.RFC3339, post.CreatedTime)
+	return created
+}
+
+// hotEpoch is the reference time of the hot ranking used by Reddit.
+var hotEpoch = time.Unix(1134028003, 0)
+
+// hotRank is Reddit's hot ranking: every order of magnitude of the score
+// is worth as much as 12.5 hours of age, so new posts push out old ones.
+func hotRank(post Post, _ time.Time) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(post.Score)), 1))
+
+	sign := 0.0
+	if post.Score > 0 {
+		sign = 1
+	} else if post.Score < 0 {
+		sign = -1
+	}
+
+	seconds := createdTime(post).Sub(hotEpoch).Seconds()
+	return sign*order + seconds/45000
+}
+
+func topRank(post Post, _ time.Time) float64 {
+	return float64(post.Score)
+}
+
+func newRank(post Post, _ time.Time) float64 {
+	return float64(createdTime(post).Unix())
+}
+
+// controversialRank is high for posts with many votes split evenly
+// between up and down.
+func controversialRank(post Post, _ time.Time) float64 {
+	return controversy(post.Votes)
+}
+
+// risingWindow is how long a post can be rising.
+const risingWindow = 24 * time.Hour
+
+// risingRank favours young posts that gain score quickly. Older posts
+// are not rising at all.
+func risingRank(post Post, now time.Time) float64 {
+	age := now.Sub(createdTime(post))
+	if age > risingWindow {
+		return math.Inf(-1)
+	}
+
+	hours := math.Max(age.Hours(), 0)
+	return float64(post.Score) / math.Pow(hours+2, 1.5)
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login of a user. It is kept alive by refresh tokens, which
+// are rotated on every use, and ends when it expires or is deleted.
+// Only hashes of refresh tokens are stored.
+type Session struct {
+	ID       string
+	UserID   string
+	UserName string
+	// RefreshHash is the hash of the current refresh token, RotatedHashes
+	// are the hashes of the last MaxRotatedHashes tokens it replaced.
+	RefreshHash   string
+	RotatedHashes []string
+	ExpiresAt     time.Time
+}
+
+type SessionStorage interface {
+	AddSession(userID, userName, refreshHash string, expiresAt time.Time) (Session, error)
+	GetSession(id string) (Session, error)
+	// RefreshSession replaces the current refresh token of a session and
+	// extends it. A token that was already replaced returns the session
+	// together with ErrRefreshTokenReused.
+	RefreshSession(refreshHash, nextHash string, expiresAt time.Time) (Session, error)
+	DeleteSession(id string) error
+	DeleteUserSessions(userID string) error
+}
+
+type SessionInMemStorage struct {
+	sessions map[string]Session
+	// refresh maps the current and rotated refresh token hashes to sessions
+	refresh map[string]string
+	mu      *sync.RWMutex
+}
+
+// MaxRotatedHashes is how many replaced refresh tokens of a session are
+// remembered to detect their reuse. Older tokens are unknown, like the
+// tokens of ended sessions.
+const MaxRotatedHashes = 16
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+func NewSessionInMemStorage() *SessionInMemStorage {
+	return &SessionInMemStorage{map[string]Session{}, map[string]string{}, &sync.RWMutex{}}
+}
+
+func (s Session) clone() Session {
+	s.RotatedHashes = slices.Clone(s.RotatedHashes)
+	return s
+}
+
+func (s Session) expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+func (s *SessionInMemStorage) AddSession(userID, userName, refreshHash string, expiresAt time.Time) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.expired(now) {
+			s.remove(id)
+		}
+	}
+
+	session := Session{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		UserName:    userName,
+		RefreshHash: refreshHash,
+		ExpiresAt:   expiresAt,
+	}
+	s.put(session)
+	return session.clone(), nil
+}
+
+func (s *SessionInMemStorage) GetSession(id string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.expired(time.Now()) {
+		return Session{}, ErrSessionNotFound
+	}
+
+	return session.clone(), nil
+}
+
+func (s *SessionInMemStorage) RefreshSession(refreshHash, nextHash string, expiresAt time.Time) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[s.refresh[refreshHash]]
+	if !ok || session.expired(time.Now()) {
+		return Session{}, ErrSessionNotFound
+	}
+	if session.RefreshHash != refreshHash {
+		return session.clone(), ErrRefreshTokenReused
+	}
+
+	session = session.clone()
+	session.RotatedHashes = append(session.RotatedHashes, session.RefreshHash)
+	if n := len(session.RotatedHashes) - MaxRotatedHashes; n > 0 {
+		session.RotatedHashes = slices.Delete(session.RotatedHashes, 0, n)
+	}
+	session.RefreshHash = nextHash
+	session.ExpiresAt = expiresAt
+	s.put(session)
+	return session.clone(), nil
+}
+
+func (s *SessionInMemStorage) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	s.remove(id)
+	return nil
+}
+
+func (s *SessionInMemStorage) DeleteUserSessions(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeUser(userID)
+	return nil
+}
+
+func (s *SessionInMemStorage) put(session Session) {
+	// forget the hashes the previous version had, old ones may be pruned
+	s.remove(session.ID)
+
+	s.sessions[session.ID] = session
+	s.refresh[session.RefreshHash] = session.ID
+	for _, hash := range session.RotatedHashes {
+		s.refresh[hash] = session.ID
+	}
+}
+
+func (s *SessionInMemStorage) remove(id string) {
+	session := s.sessions[id]
+	delete(s.refresh, session.RefreshHash)
+	for _, hash := range session.RotatedHashes {
+		delete(s.refresh, hash)
+	}
+	delete(s.sessions, id)
+}
+
+func (s *SessionInMemStorage) removeUser(userID string) {
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			s.remove(id)
+		}
+	}
+}
+
+func (s *SessionInMemStorage) putSession(session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(session)
+}
+
+func (s *SessionInMemStorage) removeSession(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(id)
+}
+
+func (s *SessionInMemStorage) removeUserSessions(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeUser(userID)
+}
+
+// getSessions returns the sessions that have not expired.
+func (s *SessionInMemStorage) getSessions() []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if !session.expired(now) {
+			sessions = append(sessions, session.clone())
+		}
+	}
+
+	return sessions
+}
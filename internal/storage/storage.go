@@ -3,16 +3,26 @@ package storage
 type Storage interface {
 	UserStorage
 	PostStorage
+	ModerationStorage
+	CommunityStorage
+	SessionStorage
+	AuditStorage
 }
 
 type InMemoryStorage struct {
 	*UserInMemStorage
 	*PostInMemStorage
+	*CommunityInMemStorage
+	*SessionInMemStorage
+	*AuditInMemStorage
 }
 
 func NewInMemStorage() InMemoryStorage {
 	return InMemoryStorage{
 		NewUserInMemStorage(),
 		NewPostInMemStorage(),
+		NewCommunityInMemStorage(),
+		NewSessionInMemStorage(),
+		NewAuditInMemStorage(),
 	}
 }
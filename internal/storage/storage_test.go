@@ -0,0 +1,188 @@
+package storage_test
+
+import (
+	"errors"
+	"path/filepath"
+	"redditclone/internal/authz"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+	"redditclone/internal/storage/storagetest"
+)
+
+func TestInMemStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewInMemStorage()
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newFileStorage(t, filepath.Join(t.TempDir(), "data.db"))
+	})
+}
+
+func TestFileStorageReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+
+	s := newFileStorage(t, path)
+	user, err := s.AddUser("alice", "secret")
+	if err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if _, err = s.AddCommunity(storage.Community{Name: "news", Rules: "be nice"}); err != nil {
+		t.Fatalf("AddCommunity: %v", err)
+	}
+	post, err := s.AddPost(storage.RawPost{Type: storage.LINK, Title: "t", Content: "https://example.com"}, user.Name, user.ID)
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+	deleted, err := s.AddPost(storage.RawPost{Type: storage.TEXT, Title: "t"}, user.Name, user.ID)
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+	if _, err = s.DownvotePost(post.ID, "bob"); err != nil {
+		t.Fatalf("DownvotePost: %v", err)
+	}
+	if _, err = s.AddComment(post.ID, user.ID, user.Name, "hello"); err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	title := "edited"
+	if _, err = s.EditPost(post.ID, authz.Subject{ID: user.ID}, storage.PostEdit{Title: &title}); err != nil {
+		t.Fatalf("EditPost: %v", err)
+	}
+	if err = s.DeletePost(deleted.ID, authz.Subject{ID: user.ID}); err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+	if _, err = s.SetUserRole(user.Name, authz.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	if _, err = s.SetUserBan(user.Name, storage.Ban{Kind: storage.BanShadow, Reason: "spam"}); err != nil {
+		t.Fatalf("SetUserBan: %v", err)
+	}
+	if _, err = s.AddModerator("news", storage.PostAuthor{Name: "bob", ID: "bob-id"}); err != nil {
+		t.Fatalf("AddModerator: %v", err)
+	}
+	if _, err = s.AddAuditEntry(storage.AuditEntry{Action: authz.DeletePost, Target: deleted.ID}); err != nil {
+		t.Fatalf("AddAuditEntry: %v", err)
+	}
+	session, err := s.AddSession(user.ID, user.Name, "hash-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	if _, err = s.RefreshSession("hash-1", "hash-2", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	ended, err := s.AddSession(user.ID, user.Name, "ended", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	if err = s.DeleteSession(ended.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = newFileStorage(t, path)
+	if got, err := s.GetUser("alice", "secret"); err != nil || got.Role != authz.RoleAdmin || got.Ban.Kind != storage.BanShadow || got.Ban.Reason != "spam" {
+		t.Errorf("GetUser after reload = %+v, %v", got, err)
+	}
+	if got := s.ShadowbannedUsers(); len(got) != 1 || got[0] != "alice" {
+		t.Errorf("ShadowbannedUsers after reload = %v, want [alice]", got)
+	}
+	if _, err = s.AddUser("Alice", "secret"); !errors.Is(err, storage.ErrUserAlreadyExists) {
+		t.Errorf("AddUser of a name differing in case after reload: got %v, want %v", err, storage.ErrUserAlreadyExists)
+	}
+
+	community, err := s.GetCommunity("news")
+	if err != nil || community.Rules != "be nice" || !community.Moderates("bob-id") {
+		t.Errorf("GetCommunity after reload = %+v, %v", community, err)
+	}
+
+	posts := s.GetPosts()
+	if len(posts) != 1 {
+		t.Fatalf("GetPosts after reload returned %d posts, want 1", len(posts))
+	}
+	got := posts[0]
+	if got.ID != post.ID || got.Content != post.Content || got.Score != 0 || len(got.Comments) != 1 ||
+		got.Title != title || len(got.Revisions) != 1 {
+		t.Errorf("post after reload = %+v", got)
+	}
+
+	if log := s.GetAuditLog(); len(log) != 1 || log[0].Target != deleted.ID {
+		t.Errorf("GetAuditLog after reload = %+v", log)
+	}
+	if _, err = s.RefreshSession("hash-1", "hash-3", time.Now().Add(time.Hour)); !errors.Is(err, storage.ErrRefreshTokenReused) {
+		t.Errorf("RefreshSession with rotated token after reload: got %v, want %v", err, storage.ErrRefreshTokenReused)
+	}
+	if got, err := s.GetSession(session.ID); err != nil || got.RefreshHash != "hash-2" {
+		t.Errorf("GetSession after reload = %+v, %v", got, err)
+	}
+	if _, err = s.GetSession(ended.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession of deleted session after reload: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+}
+
+func newFileStorage(t *testing.T, path string) *storage.FileStorage {
+	t.Helper()
+
+	s, err := storage.NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestFileStorageAddViewsJournalFailure(t *testing.T) {
+	s := newFileStorage(t, filepath.Join(t.TempDir(), "data.db"))
+	post, err := s.AddPost(storage.RawPost{Type: storage.TEXT, Title: "t"}, "alice", "alice-id")
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+	if err = s.AddViews(map[string]int{post.ID: 2}); err != nil {
+		t.Fatalf("AddViews: %v", err)
+	}
+	before, _ := s.GetPost(post.ID)
+
+	// writes to the closed journal fail
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err = s.AddViews(map[string]int{post.ID: 3}); err == nil {
+		t.Fatal("AddViews with a closed journal succeeded")
+	}
+
+	// the failed views stay pending, retrying them must not count them twice
+	if got, _ := s.GetPost(post.ID); got.Views != before.Views {
+		t.Errorf("views after a failed journal write = %d, want %d", got.Views, before.Views)
+	}
+}
+
+func TestFileStorageAddViewsRemovedPost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	s := newFileStorage(t, path)
+	post, err := s.AddPost(storage.RawPost{Type: storage.TEXT, Title: "t"}, "alice", "alice-id")
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+	removed, err := s.ModeratePost(post.ID, storage.ModRemoved, storage.PostAuthor{Name: "mod", ID: "mod-id"})
+	if err != nil {
+		t.Fatalf("ModeratePost: %v", err)
+	}
+	if err = s.AddViews(map[string]int{post.ID: 3}); err != nil {
+		t.Fatalf("AddViews: %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// removed posts get no views, in memory or in the journal
+	s = newFileStorage(t, path)
+	if got, err := s.GetPost(post.ID); err != nil || got.Views != removed.Views {
+		t.Errorf("views of the removed post after reload = %d, %v, want %d", got.Views, err, removed.Views)
+	}
+}
@@ -0,0 +1,1477 @@
+// Package storagetest implements a conformance test suite for
+// storage.Storage implementations. Every backend is expected to pass it.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"redditclone/internal/authz"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+)
+
+// Run checks the full storage.Storage contract against storages created by
+// newStorage. Every subtest gets a fresh, empty storage.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"Users", testUsers},
+		{"AddPost", testAddPost},
+		{"GetPosts", testGetPosts},
+		{"ListPosts", testListPosts},
+		{"ListPostsSorts", testListPostsSorts},
+		{"Votes", testVotes},
+		{"VoteUnknownPost", testVoteUnknownPost},
+		{"DeletePost", testDeletePost},
+		{"Comments", testComments},
+		{"DeleteComment", testDeleteComment},
+		{"Replies", testReplies},
+		{"CommentVotes", testCommentVotes},
+		{"EditPost", testEditPost},
+		{"EditComment", testEditComment},
+		{"AddViews", testAddViews},
+		{"Communities", testCommunities},
+		{"Moderators", testModerators},
+		{"Moderation", testModeration},
+		{"Sessions", testSessions},
+		{"RotatedHashes", testRotatedHashes},
+		{"Bans", testBans},
+		{"HideAuthors", testHideAuthors},
+		{"AuditLog", testAuditLog},
+		{"Concurrent", testConcurrent},
+		{"ConcurrentVotes", testConcurrentVotes},
+		{"ConcurrentComments", testConcurrentComments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func testUsers(t *testing.T, s storage.Storage) {
+	user, err := s.AddUser("alice", "secret")
+	if err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if user.ID == "" || user.Name != "alice" || user.Role != authz.RoleUser {
+		t.Errorf("AddUser returned %+v", user)
+	}
+	if string(user.Password) == "secret" {
+		t.Error("AddUser stored the password in plain text")
+	}
+
+	_, err = s.AddUser("alice", "other")
+	if !errors.Is(err, storage.ErrUserAlreadyExists) {
+		t.Errorf("AddUser duplicate: got %v, want %v", err, storage.ErrUserAlreadyExists)
+	}
+	_, err = s.AddUser("ALICE", "other")
+	if !errors.Is(err, storage.ErrUserAlreadyExists) {
+		t.Errorf("AddUser duplicate in another case: got %v, want %v", err, storage.ErrUserAlreadyExists)
+	}
+	if n := s.CountUsers(); n != 1 {
+		t.Errorf("CountUsers = %d, want 1", n)
+	}
+
+	got, err := s.GetUser("alice", "secret")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("GetUser returned ID %q, want %q", got.ID, user.ID)
+	}
+
+	_, err = s.GetUser("alice", "wrong")
+	if !errors.Is(err, storage.ErrInvalidPassword) {
+		t.Errorf("GetUser wrong password: got %v, want %v", err, storage.ErrInvalidPassword)
+	}
+
+	_, err = s.GetUser("bob", "secret")
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("GetUser unknown user: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+
+	admin, err := s.SetUserRole("alice", authz.RoleAdmin)
+	if err != nil || admin.ID != user.ID || admin.Role != authz.RoleAdmin {
+		t.Errorf("SetUserRole = %+v, %v", admin, err)
+	}
+	found, err := s.FindUser("alice")
+	if err != nil || found.Role != authz.RoleAdmin {
+		t.Errorf("FindUser after SetUserRole = %+v, %v", found, err)
+	}
+	if got, err = s.GetUser("alice", "secret"); err != nil || got.Role != authz.RoleAdmin {
+		t.Errorf("GetUser after SetUserRole = %+v, %v", got, err)
+	}
+
+	_, err = s.FindUser("bob")
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("FindUser unknown user: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+	_, err = s.SetUserRole("bob", authz.RoleAdmin)
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("SetUserRole unknown user: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+}
+
+// actor returns a regular user with the id.
+func actor(userID string) authz.Subject {
+	return authz.Subject{ID: userID, Role: authz.RoleUser}
+}
+
+func addPost(t *testing.T, s storage.Storage, authorID string) storage.Post {
+	t.Helper()
+
+	post, err := s.AddPost(storage.RawPost{
+		Type:     storage.TEXT,
+		Category: "programming",
+		Title:    "title",
+		Content:  "text",
+	}, "name-"+authorID, authorID)
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+
+	return post
+}
+
+func testAddPost(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+
+	if post.ID == "" {
+		t.Error("AddPost returned an empty ID")
+	}
+	if post.Type != storage.TEXT || post.Category != "programming" || post.Title != "title" || post.Content != "text" {
+		t.Errorf("AddPost did not keep the raw post: %+v", post.RawPost)
+	}
+	if post.Author != (storage.PostAuthor{Name: "name-author", ID: "author"}) {
+		t.Errorf("AddPost author = %+v", post.Author)
+	}
+	if post.Score != 1 || post.UpvotePercentage != 100 || post.Views != 1 {
+		t.Errorf("AddPost score = %d, upvotePercentage = %d, views = %d, want 1, 100, 1",
+			post.Score, post.UpvotePercentage, post.Views)
+	}
+	if len(post.Votes) != 1 || post.Votes[0] != (storage.Vote{UserID: "author", Vote: storage.UPVOTE}) {
+		t.Errorf("AddPost votes = %+v, want a single upvote by the author", post.Votes)
+	}
+	if post.Comments == nil || len(post.Comments) != 0 {
+		t.Errorf("AddPost comments = %#v, want an empty list", post.Comments)
+	}
+
+	got, err := s.GetPost(post.ID)
+	if err != nil {
+		t.Fatalf("GetPost: %v", err)
+	}
+	if got.ID != post.ID || got.Content != post.Content {
+		t.Errorf("GetPost = %+v, want %+v", got, post)
+	}
+
+	_, err = s.GetPost("missing")
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("GetPost unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+}
+
+func testGetPosts(t *testing.T, s storage.Storage) {
+	if posts := s.GetPosts(); len(posts) != 0 {
+		t.Fatalf("GetPosts on empty storage returned %d posts", len(posts))
+	}
+
+	ids := map[string]bool{}
+	for i := range 3 {
+		ids[addPost(t, s, fmt.Sprint("user", i)).ID] = true
+	}
+
+	posts := s.GetPosts()
+	if len(posts) != len(ids) {
+		t.Fatalf("GetPosts returned %d posts, want %d", len(posts), len(ids))
+	}
+	for _, p := range posts {
+		if !ids[p.ID] {
+			t.Errorf("GetPosts returned unknown post %q", p.ID)
+		}
+	}
+}
+
+func testListPosts(t *testing.T, s storage.Storage) {
+	// scores 1, 0, -1, ... make the top order predictable
+	var want []string
+	for i := range 7 {
+		post := addPost(t, s, "author")
+		for v := range i {
+			_, err := s.DownvotePost(post.ID, fmt.Sprint("voter", v))
+			if err != nil {
+				t.Fatalf("DownvotePost: %v", err)
+			}
+		}
+		want = append(want, post.ID)
+	}
+	other, err := s.AddPost(storage.RawPost{Type: storage.TEXT, Category: "news", Title: "t"}, "other", "other")
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+
+	query := storage.PostQuery{Category: "programming", Sort: storage.SortTop, Limit: 3}
+	var got []string
+	var pages []storage.PostPage
+	for {
+		page, err := s.ListPosts(query)
+		if err != nil {
+			t.Fatalf("ListPosts(%+v): %v", query, err)
+		}
+		pages = append(pages, page)
+		for _, p := range page.Posts {
+			got = append(got, p.ID)
+		}
+		if page.After == "" {
+			break
+		}
+		query.After = page.After
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("paging forward returned %v, want %v", got, want)
+	}
+	if len(pages) != 3 || pages[0].Before != "" || len(pages[2].Posts) != 1 {
+		t.Errorf("got %d pages, want pages of 3, 3 and 1 posts with no cursor before the first", len(pages))
+	}
+
+	query.After = ""
+	query.Before = pages[2].Before
+	back, err := s.ListPosts(query)
+	if err != nil {
+		t.Fatalf("ListPosts before: %v", err)
+	}
+	if len(back.Posts) != 3 || back.Posts[0].ID != want[3] || back.After == "" || back.Before == "" {
+		t.Errorf("paging back returned %d posts starting at %q, want the second page", len(back.Posts), back.Posts[0].ID)
+	}
+
+	all, err := s.ListPosts(storage.PostQuery{})
+	if err != nil {
+		t.Fatalf("ListPosts: %v", err)
+	}
+	if len(all.Posts) != 8 || all.After != "" {
+		t.Errorf("ListPosts without filters returned %d posts, want 8 on one page", len(all.Posts))
+	}
+
+	news, err := s.ListPosts(storage.PostQuery{Category: "news"})
+	if err != nil {
+		t.Fatalf("ListPosts: %v", err)
+	}
+	if len(news.Posts) != 1 || news.Posts[0].ID != other.ID {
+		t.Errorf("ListPosts for category returned %d posts", len(news.Posts))
+	}
+
+	byAuthor, err := s.ListPosts(storage.PostQuery{Author: "other"})
+	if err != nil {
+		t.Fatalf("ListPosts: %v", err)
+	}
+	if len(byAuthor.Posts) != 1 || byAuthor.Posts[0].ID != other.ID {
+		t.Errorf("ListPosts for author returned %d posts", len(byAuthor.Posts))
+	}
+
+	_, err = s.ListPosts(storage.PostQuery{Sort: "random"})
+	if !errors.Is(err, storage.ErrUnknownSort) {
+		t.Errorf("ListPosts unknown sort: got %v, want %v", err, storage.ErrUnknownSort)
+	}
+
+	_, err = s.ListPosts(storage.PostQuery{After: "garbage"})
+	if !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Errorf("ListPosts invalid cursor: got %v, want %v", err, storage.ErrInvalidCursor)
+	}
+}
+
+func testListPostsSorts(t *testing.T, s storage.Storage) {
+	popular := addPost(t, s, "author")
+	split := addPost(t, s, "author")
+	addPost(t, s, "author")
+	for i := range 4 {
+		voter := fmt.Sprint("voter", i)
+		if _, err := s.UpvotePost(popular.ID, voter); err != nil {
+			t.Fatalf("UpvotePost: %v", err)
+		}
+		if i%2 == 0 {
+			if _, err := s.DownvotePost(split.ID, voter); err != nil {
+				t.Fatalf("DownvotePost: %v", err)
+			}
+		} else if _, err := s.UpvotePost(split.ID, voter); err != nil {
+			t.Fatalf("UpvotePost: %v", err)
+		}
+	}
+
+	tests := []struct {
+		sort   storage.PostSort
+		window storage.TimeWindow
+		first  string
+	}{
+		{storage.SortHot, "", popular.ID},
+		{"", "", popular.ID},
+		{storage.SortTop, storage.WindowHour, popular.ID},
+		{storage.SortTop, storage.WindowAll, popular.ID},
+		{storage.SortControversial, storage.WindowDay, split.ID},
+		{storage.SortRising, "", popular.ID},
+	}
+	for _, tt := range tests {
+		page, err := s.ListPosts(storage.PostQuery{Sort: tt.sort, Window: tt.window})
+		if err != nil {
+			t.Errorf("ListPosts sort %q: %v", tt.sort, err)
+			continue
+		}
+		if len(page.Posts) != 3 || page.Posts[0].ID != tt.first {
+			t.Errorf("ListPosts sort %q returned %d posts, want 3 starting with %q", tt.sort, len(page.Posts), tt.first)
+		}
+	}
+
+	page, err := s.ListPosts(storage.PostQuery{Sort: storage.SortNew})
+	if err != nil {
+		t.Fatalf("ListPosts sort new: %v", err)
+	}
+	if len(page.Posts) != 3 {
+		t.Errorf("ListPosts sort new returned %d posts, want 3", len(page.Posts))
+	}
+	for _, p := range page.Posts[1:] {
+		if p.CreatedTime > page.Posts[0].CreatedTime {
+			t.Errorf("ListPosts sort new: post %q is newer than the first one", p.ID)
+		}
+	}
+
+	_, err = s.ListPosts(storage.PostQuery{Sort: storage.SortTop, Window: "decade"})
+	if !errors.Is(err, storage.ErrUnknownWindow) {
+		t.Errorf("ListPosts unknown window: got %v, want %v", err, storage.ErrUnknownWindow)
+	}
+}
+
+func testVotes(t *testing.T, s storage.Storage) {
+	type step struct {
+		user      string
+		vote      storage.UpDownVote
+		score     int
+		upvotePct int
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"author upvote is idempotent", []step{
+			{"author", storage.UPVOTE, 1, 100},
+		}},
+		{"author downvote", []step{
+			{"author", storage.DOWNVOTE, -1, 0},
+		}},
+		{"author unvote", []step{
+			{"author", storage.NOVOTE, 0, 0},
+		}},
+		{"upvote then downvote", []step{
+			{"u1", storage.UPVOTE, 2, 100},
+			{"u1", storage.DOWNVOTE, 0, 50},
+			{"u1", storage.DOWNVOTE, 0, 50},
+		}},
+		{"downvote then unvote", []step{
+			{"u1", storage.DOWNVOTE, 0, 50},
+			{"u1", storage.NOVOTE, 1, 100},
+			{"u1", storage.NOVOTE, 1, 100},
+		}},
+		{"several users", []step{
+			{"u1", storage.DOWNVOTE, 0, 50},
+			{"u2", storage.DOWNVOTE, -1, 33},
+			{"u3", storage.UPVOTE, 0, 50},
+			{"author", storage.NOVOTE, -1, 33},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post := addPost(t, s, "author")
+
+			for i, st := range tt.steps {
+				got, err := vote(s, st.vote)(post.ID, st.user)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if got.Score != st.score || got.UpvotePercentage != st.upvotePct {
+					t.Errorf("step %d: score = %d, upvotePercentage = %d, want %d, %d",
+						i, got.Score, got.UpvotePercentage, st.score, st.upvotePct)
+				}
+
+				stored, err := s.GetPost(post.ID)
+				if err != nil {
+					t.Fatalf("step %d: GetPost: %v", i, err)
+				}
+				if stored.Score != got.Score || len(stored.Votes) != len(got.Votes) {
+					t.Errorf("step %d: stored post differs from returned one", i)
+				}
+			}
+		})
+	}
+}
+
+func vote(s storage.Storage, v storage.UpDownVote) func(postID, userID string) (storage.Post, error) {
+	switch v {
+	case storage.UPVOTE:
+		return s.UpvotePost
+	case storage.DOWNVOTE:
+		return s.DownvotePost
+	default:
+		return s.UnvotePost
+	}
+}
+
+func testVoteUnknownPost(t *testing.T, s storage.Storage) {
+	for _, v := range []storage.UpDownVote{storage.UPVOTE, storage.DOWNVOTE, storage.NOVOTE} {
+		_, err := vote(s, v)("missing", "user")
+		if !errors.Is(err, storage.ErrPostNotFound) {
+			t.Errorf("vote %d on unknown post: got %v, want %v", v, err, storage.ErrPostNotFound)
+		}
+	}
+}
+
+func testDeletePost(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+
+	err := s.DeletePost("missing", actor("author"))
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("DeletePost unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+
+	err = s.DeletePost(post.ID, actor("stranger"))
+	if !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("DeletePost by stranger: got %v, want %v", err, storage.ErrPermissionDenied)
+	}
+	otherModerator := authz.Subject{ID: "mod", Role: authz.RoleUser, Moderates: []string{"music"}}
+	err = s.DeletePost(post.ID, otherModerator)
+	if !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("DeletePost by moderator of another community: got %v, want %v", err, storage.ErrPermissionDenied)
+	}
+
+	err = s.DeletePost(post.ID, actor("author"))
+	if err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+
+	moderated := addPost(t, s, "author")
+	moderator := authz.Subject{ID: "mod", Role: authz.RoleUser, Moderates: []string{"programming"}}
+	if err = s.DeletePost(moderated.ID, moderator); err != nil {
+		t.Errorf("DeletePost by moderator: %v", err)
+	}
+	administered := addPost(t, s, "author")
+	if err = s.DeletePost(administered.ID, authz.Subject{ID: "admin", Role: authz.RoleAdmin}); err != nil {
+		t.Errorf("DeletePost by admin: %v", err)
+	}
+	if _, err = s.EditPost(addPost(t, s, "author").ID, moderator, storage.PostEdit{}); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("EditPost by moderator: got %v, want %v", err, storage.ErrPermissionDenied)
+	}
+
+	_, err = s.GetPost(post.ID)
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("GetPost after delete: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+	if posts := s.GetPosts(); len(posts) != 1 {
+		t.Errorf("GetPosts after delete returned %d posts, want 1", len(posts))
+	}
+}
+
+func testComments(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+
+	_, err := s.AddComment("missing", "user", "name", "hello")
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("AddComment unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+
+	got, err := s.AddComment(post.ID, "user", "name", "hello")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	if len(got.Comments) != 1 {
+		t.Fatalf("AddComment: post has %d comments, want 1", len(got.Comments))
+	}
+
+	comment := got.Comments[0]
+	if comment.ID == "" || comment.Body != "hello" || comment.CreatedTime == "" {
+		t.Errorf("AddComment created %+v", comment)
+	}
+	if comment.Author != (storage.PostAuthor{Name: "name", ID: "user"}) {
+		t.Errorf("AddComment author = %+v", comment.Author)
+	}
+
+	got, err = s.AddComment(post.ID, "author", "name-author", "reply")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	if len(got.Comments) != 2 || got.Comments[0].ID != comment.ID {
+		t.Errorf("AddComment did not append to existing comments: %+v", got.Comments)
+	}
+
+	stored, err := s.GetPost(post.ID)
+	if err != nil {
+		t.Fatalf("GetPost: %v", err)
+	}
+	if len(stored.Comments) != 2 {
+		t.Errorf("stored post has %d comments, want 2", len(stored.Comments))
+	}
+}
+
+func testDeleteComment(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	post, err := s.AddComment(post.ID, "user", "name", "hello")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	commentID := post.Comments[0].ID
+
+	_, err = s.DeleteComment("missing", actor("user"), commentID)
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("DeleteComment unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+
+	_, err = s.DeleteComment(post.ID, actor("user"), "missing")
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("DeleteComment unknown comment: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+
+	_, err = s.DeleteComment(post.ID, actor("author"), commentID)
+	if !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("DeleteComment by post author: got %v, want %v", err, storage.ErrPermissionDenied)
+	}
+
+	got, err := s.DeleteComment(post.ID, actor("user"), commentID)
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	if len(got.Comments) != 0 {
+		t.Errorf("DeleteComment left %d comments", len(got.Comments))
+	}
+
+	_, err = s.DeleteComment(post.ID, actor("user"), commentID)
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("DeleteComment twice: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+
+	post, err = s.AddComment(post.ID, "user", "name", "spam")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	moderator := authz.Subject{ID: "mod", Role: authz.RoleUser, Moderates: []string{"programming"}}
+	got, err = s.DeleteComment(post.ID, moderator, post.Comments[0].ID)
+	if err != nil || len(got.Comments) != 0 {
+		t.Errorf("DeleteComment by moderator = %d comments, %v", len(got.Comments), err)
+	}
+}
+
+func testReplies(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	post, err := s.AddComment(post.ID, "user", "name", "root")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	root := post.Comments[0]
+
+	_, err = s.AddReply(post.ID, "missing", "user", "name", "reply")
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("AddReply to unknown comment: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+	_, err = s.AddReply("missing", root.ID, "user", "name", "reply")
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("AddReply to unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+
+	post, err = s.AddReply(post.ID, root.ID, "other", "other", "reply")
+	if err != nil {
+		t.Fatalf("AddReply: %v", err)
+	}
+	reply := post.Comments[1]
+	if reply.ParentID != root.ID || reply.Depth != 1 || reply.Body != "reply" {
+		t.Errorf("AddReply created %+v", reply)
+	}
+
+	post, err = s.AddReply(post.ID, reply.ID, "user", "name", "nested")
+	if err != nil {
+		t.Fatalf("AddReply: %v", err)
+	}
+	nested := post.Comments[2]
+	if nested.ParentID != reply.ID || nested.Depth != 2 {
+		t.Errorf("AddReply created %+v", nested)
+	}
+
+	threads, err := storage.BuildCommentThreads(post.Comments, storage.CommentOld)
+	if err != nil {
+		t.Fatalf("BuildCommentThreads: %v", err)
+	}
+	if len(threads) != 1 || len(threads[0].Replies) != 1 || len(threads[0].Replies[0].Replies) != 1 ||
+		threads[0].Replies[0].Replies[0].ID != nested.ID {
+		t.Errorf("BuildCommentThreads returned %+v", threads)
+	}
+
+	// deleting a comment with replies keeps a placeholder
+	post, err = s.DeleteComment(post.ID, actor("user"), root.ID)
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	if len(post.Comments) != 3 || !post.Comments[0].Deleted ||
+		post.Comments[0].Body != storage.DeletedText || post.Comments[0].Author.ID != "" {
+		t.Errorf("DeleteComment with replies left %+v", post.Comments)
+	}
+
+	_, err = s.DeleteComment(post.ID, actor("user"), root.ID)
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("DeleteComment of deleted comment: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+	_, err = s.AddReply(post.ID, root.ID, "user", "name", "reply")
+	if !errors.Is(err, storage.ErrCommentDeleted) {
+		t.Errorf("AddReply to deleted comment: got %v, want %v", err, storage.ErrCommentDeleted)
+	}
+
+	post, err = s.DeleteComment(post.ID, actor("other"), reply.ID)
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	if len(post.Comments) != 3 || !post.Comments[1].Deleted {
+		t.Errorf("DeleteComment with replies left %+v", post.Comments)
+	}
+
+	// deleting the last reply removes the placeholders above it
+	post, err = s.DeleteComment(post.ID, actor("user"), nested.ID)
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	if len(post.Comments) != 0 {
+		t.Errorf("DeleteComment left %+v, want no comments", post.Comments)
+	}
+}
+
+func testCommentVotes(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	for _, body := range []string{"first", "second", "third"} {
+		var err error
+		post, err = s.AddComment(post.ID, "user", "name", body)
+		if err != nil {
+			t.Fatalf("AddComment: %v", err)
+		}
+	}
+	first, second, third := post.Comments[0], post.Comments[1], post.Comments[2]
+	if first.Score != 1 || first.UpvotePercentage != 100 || len(first.Votes) != 1 {
+		t.Errorf("new comment rating = %+v, want a single upvote by the author", first.Rating)
+	}
+
+	voteComment := func(v storage.UpDownVote) func(postID, commentID, userID string) (storage.Post, error) {
+		switch v {
+		case storage.UPVOTE:
+			return s.UpvoteComment
+		case storage.DOWNVOTE:
+			return s.DownvoteComment
+		default:
+			return s.UnvoteComment
+		}
+	}
+
+	steps := []struct {
+		comment string
+		user    string
+		vote    storage.UpDownVote
+		score   int
+	}{
+		{second.ID, "u1", storage.UPVOTE, 2},
+		{second.ID, "u2", storage.UPVOTE, 3},
+		{second.ID, "u1", storage.UPVOTE, 3},
+		{third.ID, "u1", storage.DOWNVOTE, 0},
+		{third.ID, "u2", storage.UPVOTE, 1},
+		{third.ID, "u3", storage.DOWNVOTE, 0},
+		{first.ID, "user", storage.DOWNVOTE, -1},
+		{first.ID, "user", storage.NOVOTE, 0},
+	}
+	for i, st := range steps {
+		got, err := voteComment(st.vote)(post.ID, st.comment, st.user)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		c := got.Comments[slices.IndexFunc(got.Comments, func(c storage.Comment) bool { return c.ID == st.comment })]
+		if c.Score != st.score {
+			t.Errorf("step %d: comment score = %d, want %d", i, c.Score, st.score)
+		}
+		if got.Score != 1 {
+			t.Errorf("step %d: comment vote changed the post score to %d", i, got.Score)
+		}
+	}
+
+	for _, v := range []storage.UpDownVote{storage.UPVOTE, storage.DOWNVOTE, storage.NOVOTE} {
+		_, err := voteComment(v)(post.ID, "missing", "user")
+		if !errors.Is(err, storage.ErrCommentNotFound) {
+			t.Errorf("vote %d on unknown comment: got %v, want %v", v, err, storage.ErrCommentNotFound)
+		}
+		_, err = voteComment(v)("missing", first.ID, "user")
+		if !errors.Is(err, storage.ErrPostNotFound) {
+			t.Errorf("vote %d on unknown post: got %v, want %v", v, err, storage.ErrPostNotFound)
+		}
+	}
+
+	post, err := s.GetPost(post.ID)
+	if err != nil {
+		t.Fatalf("GetPost: %v", err)
+	}
+
+	tests := []struct {
+		sort storage.CommentSort
+		want []string
+	}{
+		{storage.CommentOld, []string{first.ID, second.ID, third.ID}},
+		{storage.CommentNew, []string{third.ID, second.ID, first.ID}},
+		{storage.CommentTop, []string{second.ID, first.ID, third.ID}},
+		{storage.CommentBest, []string{second.ID, third.ID, first.ID}},
+		{storage.CommentControversial, []string{third.ID, first.ID, second.ID}},
+	}
+	for _, tt := range tests {
+		threads, err := storage.BuildCommentThreads(post.Comments, tt.sort)
+		if err != nil {
+			t.Errorf("BuildCommentThreads(%q): %v", tt.sort, err)
+			continue
+		}
+
+		var got []string
+		for _, th := range threads {
+			got = append(got, th.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("BuildCommentThreads(%q) order = %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+
+	_, err = storage.BuildCommentThreads(post.Comments, "random")
+	if !errors.Is(err, storage.ErrUnknownSort) {
+		t.Errorf("BuildCommentThreads unknown sort: got %v, want %v", err, storage.ErrUnknownSort)
+	}
+}
+
+func testEditPost(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	title, text := "new title", "new text"
+
+	_, err := s.EditPost("missing", actor("author"), storage.PostEdit{Text: &text})
+	if !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("EditPost unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+	_, err = s.EditPost(post.ID, actor("stranger"), storage.PostEdit{Text: &text})
+	if !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("EditPost by stranger: got %v, want %v", err, storage.ErrPermissionDenied)
+	}
+
+	got, err := s.EditPost(post.ID, actor("author"), storage.PostEdit{Text: &text})
+	if err != nil {
+		t.Fatalf("EditPost: %v", err)
+	}
+	if got.Content != text || got.Title != post.Title || got.EditedTime == "" {
+		t.Errorf("EditPost text: got %+v", got)
+	}
+
+	got, err = s.EditPost(post.ID, actor("author"), storage.PostEdit{Title: &title, Text: &text})
+	if err != nil {
+		t.Fatalf("EditPost: %v", err)
+	}
+	if got.Title != title || got.Content != text {
+		t.Errorf("EditPost title: got %+v", got.RawPost)
+	}
+
+	// an edit without changes does not make a revision
+	got, err = s.EditPost(post.ID, actor("author"), storage.PostEdit{Title: &title})
+	if err != nil {
+		t.Fatalf("EditPost: %v", err)
+	}
+
+	want := []storage.Revision{
+		{Title: "title", Body: "text", CreatedTime: post.CreatedTime},
+		{Title: "title", Body: text},
+	}
+	if len(got.Revisions) != len(want) {
+		t.Fatalf("post has %d revisions, want %d", len(got.Revisions), len(want))
+	}
+	for i, r := range got.Revisions {
+		if r.Title != want[i].Title || r.Body != want[i].Body || r.CreatedTime == "" {
+			t.Errorf("revision %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+
+	stored, err := s.GetPost(post.ID)
+	if err != nil {
+		t.Fatalf("GetPost: %v", err)
+	}
+	if stored.Title != title || len(stored.Revisions) != 2 {
+		t.Errorf("stored post has title %q and %d revisions", stored.Title, len(stored.Revisions))
+	}
+
+	link, err := s.AddPost(storage.RawPost{Type: storage.LINK, Title: "t", Content: "https://example.com"}, "author", "author")
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+	_, err = s.EditPost(link.ID, actor("author"), storage.PostEdit{Text: &text})
+	if !errors.Is(err, storage.ErrNotTextPost) {
+		t.Errorf("EditPost link: got %v, want %v", err, storage.ErrNotTextPost)
+	}
+}
+
+func testEditComment(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	post, err := s.AddComment(post.ID, "user", "name", "hello")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	comment := post.Comments[0]
+
+	_, err = s.EditComment(post.ID, "missing", actor("user"), "edited")
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("EditComment unknown comment: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+	_, err = s.EditComment(post.ID, comment.ID, actor("author"), "edited")
+	if !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("EditComment by post author: got %v, want %v", err, storage.ErrPermissionDenied)
+	}
+
+	got, err := s.EditComment(post.ID, comment.ID, actor("user"), "edited")
+	if err != nil {
+		t.Fatalf("EditComment: %v", err)
+	}
+	edited := got.Comments[0]
+	if edited.Body != "edited" || edited.EditedTime == "" || len(edited.Revisions) != 1 ||
+		edited.Revisions[0].Body != "hello" || edited.Revisions[0].CreatedTime != comment.CreatedTime {
+		t.Errorf("EditComment: got %+v", edited)
+	}
+
+	_, err = s.DeleteComment(post.ID, actor("user"), comment.ID)
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	_, err = s.EditComment(post.ID, comment.ID, actor("user"), "again")
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("EditComment deleted comment: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+}
+
+func testAddViews(t *testing.T, s storage.Storage) {
+	first := addPost(t, s, "author")
+	second := addPost(t, s, "author")
+
+	err := s.AddViews(map[string]int{first.ID: 3, second.ID: 1, "missing": 5})
+	if err != nil {
+		t.Fatalf("AddViews: %v", err)
+	}
+	err = s.AddViews(map[string]int{first.ID: 2})
+	if err != nil {
+		t.Fatalf("AddViews: %v", err)
+	}
+
+	for id, want := range map[string]int{first.ID: 6, second.ID: 2} {
+		post, err := s.GetPost(id)
+		if err != nil {
+			t.Fatalf("GetPost: %v", err)
+		}
+		if post.Views != want {
+			t.Errorf("post %q has %d views, want %d", id, post.Views, want)
+		}
+	}
+}
+
+func testCommunities(t *testing.T, s storage.Storage) {
+	if communities := s.GetCommunities(); len(communities) != 0 {
+		t.Fatalf("GetCommunities on empty storage returned %d communities", len(communities))
+	}
+
+	creator := storage.PostAuthor{Name: "alice", ID: "alice-id"}
+	news, err := s.AddCommunity(storage.Community{
+		Name:        "news",
+		Description: "news of the day",
+		Rules:       "be nice",
+		Creator:     creator,
+		PostTypes:   []storage.PostType{storage.LINK},
+		Restricted:  true,
+	})
+	if err != nil {
+		t.Fatalf("AddCommunity: %v", err)
+	}
+	if news.CreatedTime == "" || news.Description != "news of the day" || news.Creator != creator {
+		t.Errorf("AddCommunity returned %+v", news)
+	}
+
+	_, err = s.AddCommunity(storage.Community{Name: "news"})
+	if !errors.Is(err, storage.ErrCommunityAlreadyExists) {
+		t.Errorf("AddCommunity duplicate: got %v, want %v", err, storage.ErrCommunityAlreadyExists)
+	}
+
+	if _, err = s.AddCommunity(storage.Community{Name: "music"}); err != nil {
+		t.Fatalf("AddCommunity: %v", err)
+	}
+
+	got, err := s.GetCommunity("news")
+	if err != nil {
+		t.Fatalf("GetCommunity: %v", err)
+	}
+	if got.Rules != "be nice" || !got.Restricted || !slices.Equal(got.PostTypes, []storage.PostType{storage.LINK}) {
+		t.Errorf("GetCommunity returned %+v", got)
+	}
+
+	_, err = s.GetCommunity("missing")
+	if !errors.Is(err, storage.ErrCommunityNotFound) {
+		t.Errorf("GetCommunity unknown community: got %v, want %v", err, storage.ErrCommunityNotFound)
+	}
+
+	communities := s.GetCommunities()
+	if len(communities) != 2 || communities[0].Name != "music" || communities[1].Name != "news" {
+		t.Errorf("GetCommunities returned %+v, want music and news", communities)
+	}
+
+	if err = got.CanPost(creator.ID, storage.LINK); err != nil {
+		t.Errorf("CanPost by creator: %v", err)
+	}
+	if err = got.CanPost("stranger", storage.LINK); !errors.Is(err, storage.ErrPostingRestricted) {
+		t.Errorf("CanPost by stranger: got %v, want %v", err, storage.ErrPostingRestricted)
+	}
+	if err = got.CanPost(creator.ID, storage.TEXT); !errors.Is(err, storage.ErrPostTypeNotAllowed) {
+		t.Errorf("CanPost text: got %v, want %v", err, storage.ErrPostTypeNotAllowed)
+	}
+}
+
+func testModerators(t *testing.T, s storage.Storage) {
+	creator := storage.PostAuthor{Name: "alice", ID: "alice-id"}
+	bob := storage.PostAuthor{Name: "bob", ID: "bob-id"}
+	for _, c := range []storage.Community{{Name: "news", Creator: creator}, {Name: "music"}} {
+		if _, err := s.AddCommunity(c); err != nil {
+			t.Fatalf("AddCommunity: %v", err)
+		}
+	}
+
+	if got := s.ModeratedCommunities(creator.ID); !slices.Equal(got, []string{"news"}) {
+		t.Errorf("ModeratedCommunities of creator = %v, want [news]", got)
+	}
+
+	for range 2 {
+		news, err := s.AddModerator("news", bob)
+		if err != nil {
+			t.Fatalf("AddModerator: %v", err)
+		}
+		if !slices.Equal(news.Moderators, []storage.PostAuthor{bob}) || !news.Moderates(bob.ID) {
+			t.Errorf("AddModerator returned moderators %v", news.Moderators)
+		}
+	}
+	if _, err := s.AddModerator("music", bob); err != nil {
+		t.Fatalf("AddModerator: %v", err)
+	}
+	if _, err := s.AddModerator("missing", bob); !errors.Is(err, storage.ErrCommunityNotFound) {
+		t.Errorf("AddModerator unknown community: got %v, want %v", err, storage.ErrCommunityNotFound)
+	}
+	if got := s.ModeratedCommunities(bob.ID); !slices.Equal(got, []string{"music", "news"}) {
+		t.Errorf("ModeratedCommunities = %v, want [music news]", got)
+	}
+
+	news, err := s.RemoveModerator("news", bob.ID)
+	if err != nil || len(news.Moderators) != 0 || news.Moderates(bob.ID) {
+		t.Errorf("RemoveModerator = %+v, %v", news, err)
+	}
+	if got := s.ModeratedCommunities(bob.ID); !slices.Equal(got, []string{"music"}) {
+		t.Errorf("ModeratedCommunities after RemoveModerator = %v, want [music]", got)
+	}
+	if _, err = s.RemoveModerator("missing", bob.ID); !errors.Is(err, storage.ErrCommunityNotFound) {
+		t.Errorf("RemoveModerator unknown community: got %v, want %v", err, storage.ErrCommunityNotFound)
+	}
+}
+
+func testModeration(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	post, err := s.AddComment(post.ID, "user", "name", "spam")
+	if err != nil {
+		t.Fatalf("AddComment: %v", err)
+	}
+	comment := post.Comments[0]
+	other := addPost(t, s, "author")
+	moderator := storage.PostAuthor{Name: "mod", ID: "mod-id"}
+
+	if queue := s.GetModQueue(storage.ModQueueQuery{}); len(queue) != 0 {
+		t.Fatalf("GetModQueue without reports = %+v", queue)
+	}
+
+	for _, user := range []string{"a", "b", "a"} {
+		if _, err = s.ReportComment(post.ID, comment.ID, storage.Report{UserID: user, Reason: "spam"}); err != nil {
+			t.Fatalf("ReportComment: %v", err)
+		}
+	}
+	if _, err = s.ReportPost(post.ID, storage.Report{UserID: "a", Reason: "off topic"}); err != nil {
+		t.Fatalf("ReportPost: %v", err)
+	}
+	if _, err = s.ReportPost("missing", storage.Report{UserID: "a"}); !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("ReportPost unknown post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+	if _, err = s.ReportComment(post.ID, "missing", storage.Report{UserID: "a"}); !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("ReportComment unknown comment: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+
+	queue := s.GetModQueue(storage.ModQueueQuery{})
+	if len(queue) != 2 {
+		t.Fatalf("GetModQueue returned %d items, want 2", len(queue))
+	}
+	if queue[0].CommentID != comment.ID || len(queue[0].Reports) != 2 || queue[0].Body != "spam" || queue[0].Status != storage.ModOpen {
+		t.Errorf("GetModQueue first item = %+v, want the comment with 2 reports", queue[0])
+	}
+	if queue[1].PostID != post.ID || queue[1].CommentID != "" || queue[1].Title != "title" {
+		t.Errorf("GetModQueue second item = %+v, want the post", queue[1])
+	}
+	if queue := s.GetModQueue(storage.ModQueueQuery{Communities: []string{"news"}}); len(queue) != 0 {
+		t.Errorf("GetModQueue of another community = %+v", queue)
+	}
+
+	if _, err = s.ModeratePost(post.ID, storage.ModOpen, moderator); !errors.Is(err, storage.ErrUnknownDecision) {
+		t.Errorf("ModeratePost with unknown decision: got %v, want %v", err, storage.ErrUnknownDecision)
+	}
+
+	// removal hides the post but keeps it
+	removed, err := s.ModeratePost(post.ID, storage.ModRemoved, moderator)
+	if err != nil {
+		t.Fatalf("ModeratePost: %v", err)
+	}
+	if !removed.Moderation.Removed() || *removed.Moderation.Moderator != moderator {
+		t.Errorf("ModeratePost returned moderation %+v", removed.Moderation)
+	}
+	if posts := s.GetPosts(); len(posts) != 1 || posts[0].ID != other.ID {
+		t.Errorf("GetPosts after removal returned %d posts, want only the other one", len(posts))
+	}
+	page, err := s.ListPosts(storage.PostQuery{Sort: storage.SortNew})
+	if err != nil || len(page.Posts) != 1 {
+		t.Errorf("ListPosts after removal = %d posts, %v, want 1", len(page.Posts), err)
+	}
+	if _, err = s.UpvotePost(post.ID, "voter"); !errors.Is(err, storage.ErrPostNotFound) {
+		t.Errorf("UpvotePost of removed post: got %v, want %v", err, storage.ErrPostNotFound)
+	}
+	if got, err := s.GetPost(post.ID); err != nil || got.Title != "title" {
+		t.Errorf("GetPost of removed post = %+v, %v", got, err)
+	}
+	if queue := s.GetModQueue(storage.ModQueueQuery{Status: storage.ModRemoved}); len(queue) != 1 || queue[0].PostID != post.ID {
+		t.Errorf("GetModQueue of removed items = %+v", queue)
+	}
+
+	// comments under a removed post can still be moderated
+	if _, err = s.ModerateComment(post.ID, comment.ID, storage.ModApproved, moderator); err != nil {
+		t.Errorf("ModerateComment under removed post: %v", err)
+	}
+	if _, err = s.ModerateComment(post.ID, "unknown", storage.ModApproved, moderator); !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("ModerateComment of unknown comment under removed post: got %v, want %v", err, storage.ErrCommentNotFound)
+	}
+
+	// approving reverses the removal
+	if _, err = s.ModeratePost(post.ID, storage.ModApproved, moderator); err != nil {
+		t.Fatalf("ModeratePost: %v", err)
+	}
+	if posts := s.GetPosts(); len(posts) != 2 {
+		t.Errorf("GetPosts after approval returned %d posts, want 2", len(posts))
+	}
+
+	post, err = s.ModerateComment(post.ID, comment.ID, storage.ModRemoved, moderator)
+	if err != nil {
+		t.Fatalf("ModerateComment: %v", err)
+	}
+	if got := post.Comments[0]; !got.Moderation.Removed() || got.Body != "spam" {
+		t.Errorf("ModerateComment stored comment %+v", got)
+	}
+	if _, err = s.UpvoteComment(post.ID, comment.ID, "voter"); !errors.Is(err, storage.ErrCommentDeleted) {
+		t.Errorf("UpvoteComment of removed comment: got %v, want %v", err, storage.ErrCommentDeleted)
+	}
+	threads, err := storage.BuildCommentThreads(post.Comments, storage.CommentBest)
+	if err != nil || len(threads) != 1 || threads[0].Body != storage.RemovedText || !threads[0].Removed {
+		t.Errorf("BuildCommentThreads with removed comment = %+v, %v", threads, err)
+	}
+
+	if _, err = s.ModerateComment(post.ID, comment.ID, storage.ModDismissed, moderator); err != nil {
+		t.Fatalf("ModerateComment: %v", err)
+	}
+	if queue := s.GetModQueue(storage.ModQueueQuery{}); len(queue) != 0 {
+		t.Errorf("GetModQueue after decisions = %+v, want empty", queue)
+	}
+
+	// new reports reopen kept items
+	if _, err = s.ReportComment(post.ID, comment.ID, storage.Report{UserID: "c", Reason: "spam"}); err != nil {
+		t.Fatalf("ReportComment: %v", err)
+	}
+	if queue := s.GetModQueue(storage.ModQueueQuery{}); len(queue) != 1 || len(queue[0].Reports) != 3 {
+		t.Errorf("GetModQueue after new report = %+v", queue)
+	}
+}
+
+func testAuditLog(t *testing.T, s storage.Storage) {
+	if log := s.GetAuditLog(); log == nil || len(log) != 0 {
+		t.Fatalf("GetAuditLog on empty storage = %v, want empty", log)
+	}
+
+	actor := storage.PostAuthor{Name: "mod", ID: "mod-id"}
+	first, err := s.AddAuditEntry(storage.AuditEntry{
+		Actor:     actor,
+		Role:      "moderator",
+		Action:    authz.DeletePost,
+		Community: "news",
+		Target:    "post-id",
+	})
+	if err != nil {
+		t.Fatalf("AddAuditEntry: %v", err)
+	}
+	if first.ID == "" || first.CreatedTime == "" || first.Actor != actor || first.Target != "post-id" {
+		t.Errorf("AddAuditEntry returned %+v", first)
+	}
+	second, err := s.AddAuditEntry(storage.AuditEntry{Actor: actor, Role: "admin", Action: authz.ManageUsers, Target: "bob"})
+	if err != nil {
+		t.Fatalf("AddAuditEntry: %v", err)
+	}
+
+	log := s.GetAuditLog()
+	if len(log) != 2 || log[0].ID != second.ID || log[1].ID != first.ID {
+		t.Errorf("GetAuditLog = %+v, want the newest entry first", log)
+	}
+}
+
+func testBans(t *testing.T, s storage.Storage) {
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := s.AddUser(name, "secret"); err != nil {
+			t.Fatalf("AddUser: %v", err)
+		}
+	}
+
+	_, err := s.SetUserBan("alice", storage.Ban{Kind: "forever"})
+	if !errors.Is(err, storage.ErrUnknownBan) {
+		t.Errorf("SetUserBan with unknown kind: got %v, want %v", err, storage.ErrUnknownBan)
+	}
+	_, err = s.SetUserBan("alice", storage.Ban{Kind: storage.BanSuspended, Until: time.Now().Add(-time.Hour)})
+	if !errors.Is(err, storage.ErrInvalidUntil) {
+		t.Errorf("SetUserBan with past suspension: got %v, want %v", err, storage.ErrInvalidUntil)
+	}
+	_, err = s.SetUserBan("carol", storage.Ban{Kind: storage.BanPermanent})
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("SetUserBan of unknown user: got %v, want %v", err, storage.ErrUserNotFound)
+	}
+
+	until := time.Now().Add(time.Hour)
+	user, err := s.SetUserBan("alice", storage.Ban{Kind: storage.BanSuspended, Reason: "spam", Until: until})
+	if err != nil {
+		t.Fatalf("SetUserBan: %v", err)
+	}
+	if user.Ban.Reason != "spam" || user.Ban.CreatedTime == "" || user.Ban.Effective(time.Now()) != storage.BanSuspended {
+		t.Errorf("SetUserBan returned %+v", user.Ban)
+	}
+	if kind := user.Ban.Effective(until); kind != storage.BanNone {
+		t.Errorf("suspension is %q after it ended, want none", kind)
+	}
+
+	if _, err = s.SetUserBan("bob", storage.Ban{Kind: storage.BanShadow}); err != nil {
+		t.Fatalf("SetUserBan: %v", err)
+	}
+	if got := s.ShadowbannedUsers(); !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("ShadowbannedUsers = %v, want [bob]", got)
+	}
+
+	user, err = s.SetUserBan("bob", storage.Ban{})
+	if err != nil || user.Ban != (storage.Ban{}) {
+		t.Errorf("lifting ban = %+v, %v", user.Ban, err)
+	}
+	if got := s.ShadowbannedUsers(); len(got) != 0 {
+		t.Errorf("ShadowbannedUsers after lifting = %v, want none", got)
+	}
+	if user, _ = s.FindUser("alice"); user.Ban.Kind != storage.BanSuspended {
+		t.Errorf("FindUser returned ban %+v", user.Ban)
+	}
+}
+
+func testHideAuthors(t *testing.T, s storage.Storage) {
+	post := addPost(t, s, "author")
+	post, _ = s.AddComment(post.ID, "bob", "bob", "hidden")
+	hiddenID := post.Comments[0].ID
+	post, _ = s.AddReply(post.ID, hiddenID, "alice", "alice", "reply to hidden")
+	post, _ = s.AddComment(post.ID, "alice", "alice", "visible")
+
+	got := storage.HideAuthors(post, []string{"bob"})
+	if len(got.Comments) != 1 || got.Comments[0].Body != "visible" {
+		t.Errorf("HideAuthors left comments %+v", got.Comments)
+	}
+	if len(post.Comments) != 3 {
+		t.Errorf("HideAuthors changed the original post")
+	}
+
+	other := addPost(t, s, "bob")
+	page, err := s.ListPosts(storage.PostQuery{HiddenAuthors: []string{"name-bob"}})
+	if err != nil {
+		t.Fatalf("ListPosts: %v", err)
+	}
+	if slices.ContainsFunc(page.Posts, func(p storage.Post) bool { return p.ID == other.ID }) || len(page.Posts) != 1 {
+		t.Errorf("ListPosts with hidden author returned %d posts", len(page.Posts))
+	}
+}
+
+func testSessions(t *testing.T, s storage.Storage) {
+	expires := time.Now().Add(time.Hour)
+	session, err := s.AddSession("alice-id", "alice", "hash-1", expires)
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	other, err := s.AddSession("alice-id", "alice", "other-1", expires)
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	bobs, err := s.AddSession("bob-id", "bob", "bob-1", expires)
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+
+	got, err := s.GetSession(session.ID)
+	if err != nil || got.UserID != "alice-id" || got.UserName != "alice" || got.RefreshHash != "hash-1" {
+		t.Errorf("GetSession = %+v, %v", got, err)
+	}
+
+	refreshed, err := s.RefreshSession("hash-1", "hash-2", expires.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if refreshed.ID != session.ID || refreshed.RefreshHash != "hash-2" || !refreshed.ExpiresAt.Equal(expires.Add(time.Hour)) {
+		t.Errorf("RefreshSession returned %+v", refreshed)
+	}
+
+	reused, err := s.RefreshSession("hash-1", "hash-3", expires)
+	if !errors.Is(err, storage.ErrRefreshTokenReused) || reused.ID != session.ID {
+		t.Errorf("RefreshSession with rotated token = %+v, %v, want session and %v", reused, err, storage.ErrRefreshTokenReused)
+	}
+	_, err = s.RefreshSession("unknown", "hash-3", expires)
+	if !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("RefreshSession with unknown token: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+
+	if err = s.DeleteSession(session.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err = s.GetSession(session.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession after delete: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, err = s.RefreshSession("hash-2", "hash-3", expires); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("RefreshSession after delete: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+
+	if err = s.DeleteUserSessions("alice-id"); err != nil {
+		t.Fatalf("DeleteUserSessions: %v", err)
+	}
+	if _, err = s.GetSession(other.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession after DeleteUserSessions: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, err = s.GetSession(bobs.ID); err != nil {
+		t.Errorf("GetSession of another user after DeleteUserSessions: %v", err)
+	}
+
+	expired, err := s.AddSession("bob-id", "bob", "expired", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	if _, err = s.GetSession(expired.ID); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("GetSession of expired session: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+}
+
+func testRotatedHashes(t *testing.T, s storage.Storage) {
+	expires := time.Now().Add(time.Hour)
+	_, err := s.AddSession("alice-id", "alice", "hash-0", expires)
+	if err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+
+	const refreshes = storage.MaxRotatedHashes + 4
+	var session storage.Session
+	for i := range refreshes {
+		session, err = s.RefreshSession(fmt.Sprintf("hash-%d", i), fmt.Sprintf("hash-%d", i+1), expires)
+		if err != nil {
+			t.Fatalf("RefreshSession %d: %v", i, err)
+		}
+	}
+	if len(session.RotatedHashes) != storage.MaxRotatedHashes {
+		t.Errorf("session remembers %d rotated hashes, want %d", len(session.RotatedHashes), storage.MaxRotatedHashes)
+	}
+
+	// the oldest remembered token is still recognized as reused
+	oldest := fmt.Sprintf("hash-%d", refreshes-storage.MaxRotatedHashes)
+	if _, err = s.RefreshSession(oldest, "next", expires); !errors.Is(err, storage.ErrRefreshTokenReused) {
+		t.Errorf("RefreshSession with remembered token: got %v, want %v", err, storage.ErrRefreshTokenReused)
+	}
+	if _, err = s.RefreshSession("hash-0", "next", expires); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("RefreshSession with forgotten token: got %v, want %v", err, storage.ErrSessionNotFound)
+	}
+	if _, err = s.RefreshSession(fmt.Sprintf("hash-%d", refreshes), "next", expires); err != nil {
+		t.Errorf("RefreshSession with current token: %v", err)
+	}
+}
+
+func testConcurrent(t *testing.T, s storage.Storage) {
+	const workers = 8
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user := fmt.Sprint("user", i)
+			post, err := s.AddPost(storage.RawPost{Type: storage.TEXT, Title: user}, user, user)
+			if err != nil {
+				t.Errorf("AddPost: %v", err)
+				return
+			}
+
+			_, err = s.DownvotePost(post.ID, user)
+			if err != nil {
+				t.Errorf("DownvotePost: %v", err)
+			}
+			_, err = s.AddComment(post.ID, user, user, "comment")
+			if err != nil {
+				t.Errorf("AddComment: %v", err)
+			}
+
+			for _, p := range s.GetPosts() {
+				_, err := s.GetPost(p.ID)
+				if err != nil && !errors.Is(err, storage.ErrPostNotFound) {
+					t.Errorf("GetPost: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	posts := s.GetPosts()
+	if len(posts) != workers {
+		t.Fatalf("GetPosts returned %d posts, want %d", len(posts), workers)
+	}
+	for _, p := range posts {
+		if p.Score != -1 || len(p.Comments) != 1 {
+			t.Errorf("post %q: score = %d, comments = %d, want -1, 1", p.ID, p.Score, len(p.Comments))
+		}
+	}
+}
+
+// testConcurrentVotes makes many users vote on the same posts at once. Every
+// user ends with an upvote, so a lost update shows up in the final score.
+// Run it with -race to also catch unsynchronized access.
+func testConcurrentVotes(t *testing.T, s storage.Storage) {
+	const (
+		users = 32
+		posts = 3
+	)
+
+	ids := make([]string, posts)
+	for i := range ids {
+		ids[i] = addPost(t, s, "author").ID
+	}
+
+	var wg sync.WaitGroup
+	for u := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user := fmt.Sprint("user", u)
+			votes := []storage.UpDownVote{storage.DOWNVOTE, storage.UPVOTE, storage.NOVOTE, storage.DOWNVOTE, storage.UPVOTE}
+			for i, v := range votes {
+				for _, id := range ids {
+					post, err := vote(s, v)(id, user)
+					if err != nil {
+						t.Errorf("vote %d: %v", i, err)
+						return
+					}
+					checkScore(t, post)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		post, err := s.GetPost(id)
+		if err != nil {
+			t.Fatalf("GetPost: %v", err)
+		}
+		checkScore(t, post)
+		if post.Score != users+1 || len(post.Votes) != users+1 || post.UpvotePercentage != 100 {
+			t.Errorf("post %q: score = %d, votes = %d, upvotePercentage = %d, want %d, %d, 100",
+				id, post.Score, len(post.Votes), post.UpvotePercentage, users+1, users+1)
+		}
+	}
+}
+
+// checkScore verifies that the score agrees with the list of votes.
+func checkScore(t *testing.T, post storage.Post) {
+	t.Helper()
+
+	sum := 0
+	seen := map[string]bool{}
+	for _, v := range post.Votes {
+		if seen[v.UserID] {
+			t.Errorf("post %q: user %q voted twice", post.ID, v.UserID)
+		}
+		seen[v.UserID] = true
+		sum += int(v.Vote)
+	}
+	if sum != post.Score {
+		t.Errorf("post %q: score = %d, but votes sum to %d", post.ID, post.Score, sum)
+	}
+}
+
+// testConcurrentComments adds and deletes comments on the same post from
+// many goroutines while others read it.
+func testConcurrentComments(t *testing.T, s storage.Storage) {
+	const (
+		users    = 16
+		comments = 10
+	)
+
+	post := addPost(t, s, "author")
+
+	var wg sync.WaitGroup
+	for u := range users {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			user := fmt.Sprint("user", u)
+			for i := range comments {
+				got, err := s.AddComment(post.ID, user, user, fmt.Sprint("comment ", i))
+				if err != nil {
+					t.Errorf("AddComment: %v", err)
+					return
+				}
+
+				if i%2 == 0 {
+					continue
+				}
+
+				var mine storage.Comment
+				for _, c := range got.Comments {
+					if c.Author.ID == user && c.Body == fmt.Sprint("comment ", i) {
+						mine = c
+					}
+				}
+				_, err = s.DeleteComment(post.ID, actor(user), mine.ID)
+				if err != nil {
+					t.Errorf("DeleteComment: %v", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			for range comments {
+				_, err := s.GetPost(post.ID)
+				if err != nil {
+					t.Errorf("GetPost: %v", err)
+				}
+				s.GetPosts()
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.GetPost(post.ID)
+	if err != nil {
+		t.Fatalf("GetPost: %v", err)
+	}
+	if want := users * comments / 2; len(got.Comments) != want {
+		t.Errorf("post has %d comments, want %d", len(got.Comments), want)
+	}
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"errors"
+	"redditclone/internal/authz"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
@@ -12,24 +14,53 @@ type User struct {
 	ID       string
 	Name     string
 	Password []byte
+	Role     authz.Role
+	Ban      Ban
 }
 
 type UserStorage interface {
 	GetUser(name, password string) (User, error)
+	// AddUser returns ErrUserAlreadyExists if the name is taken in any case.
 	AddUser(name, password string) (User, error)
+	// FindUser looks a user up without checking the password.
+	FindUser(name string) (User, error)
+	SetUserRole(name string, role authz.Role) (User, error)
+	// SetUserBan returns ErrUnknownBan or ErrInvalidUntil for invalid bans.
+	SetUserBan(name string, ban Ban) (User, error)
+	// ShadowbannedUsers returns the names of shadowbanned users, sorted.
+	ShadowbannedUsers() []string
+	CountUsers() int
 }
 
 type UserInMemStorage struct {
 	users map[string]User
-	mu    *sync.RWMutex
+	// names maps lowercased names to the names users registered with,
+	// names differing only in case would be mistaken for each other
+	names map[string]string
+	// shadowbanned holds the names of shadowbanned users, so reads that
+	// hide them do not scan all users
+	shadowbanned map[string]bool
+	mu           *sync.RWMutex
 }
 
 var ErrUserAlreadyExists = errors.New("already exists")
 var ErrUserNotFound = errors.New("user not found")
 var ErrInvalidPassword = errors.New("invalid password")
 
+// dummyHash is compared against the password of unknown users, so logins
+// take as long whether the user exists or not.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
 func NewUserInMemStorage() *UserInMemStorage {
-	return &UserInMemStorage{map[string]User{}, &sync.RWMutex{}}
+	return &UserInMemStorage{
+		users:        map[string]User{},
+		names:        map[string]string{},
+		shadowbanned: map[string]bool{},
+		mu:           &sync.RWMutex{},
+	}
 }
 
 func (s *UserInMemStorage) GetUser(name, password string) (User, error) {
@@ -38,6 +69,7 @@ func (s *UserInMemStorage) GetUser(name, password string) (User, error) {
 
 	user, ok := s.users[name]
 	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
 		return User{}, ErrUserNotFound
 	}
 
@@ -53,8 +85,7 @@ func (s *UserInMemStorage) AddUser(name, password string) (User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, ok := s.users[name]
-	if ok {
+	if _, ok := s.names[strings.ToLower(name)]; ok {
 		return User{}, ErrUserAlreadyExists
 	}
 
@@ -67,7 +98,63 @@ func (s *UserInMemStorage) AddUser(name, password string) (User, error) {
 		ID:       uuid.NewString(),
 		Name:     name,
 		Password: hashedPassword,
+		Role:     authz.RoleUser,
 	}
 	s.users[name] = u
+	s.names[strings.ToLower(name)] = name
 	return u, nil
 }
+
+func (s *UserInMemStorage) FindUser(name string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (s *UserInMemStorage) CountUsers() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.users)
+}
+
+func (s *UserInMemStorage) SetUserRole(name string, role authz.Role) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+
+	user.Role = role
+	s.users[name] = user
+	return user, nil
+}
+
+func (s *UserInMemStorage) putUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.Name] = user
+	s.names[strings.ToLower(user.Name)] = user.Name
+	s.indexBan(user)
+}
+
+func (s *UserInMemStorage) getUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+
+	return users
+}
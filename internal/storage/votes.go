@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"math"
+	"slices"
+)
+
+// Rating is the score of something users vote on: posts and comments.
+type Rating struct {
+	Score            int    `json:"score"`
+	UpvotePercentage int    `json:"upvotePercentage"`
+	Votes            []Vote `json:"votes"`
+}
+
+// newRating returns the rating of a new item, which its author upvotes.
+func newRating(authorID string) Rating {
+	return Rating{
+		Score:            1,
+		UpvotePercentage: 100,
+		Votes:            []Vote{{UserID: authorID, Vote: UPVOTE}},
+	}
+}
+
+// vote replaces the vote of the user, NOVOTE takes it back.
+func (r *Rating) vote(userID string, newVote UpDownVote) {
+	oldVote := NOVOTE
+	i := slices.IndexFunc(r.Votes, func(v Vote) bool {
+		return v.UserID == userID
+	})
+	if i != -1 {
+		oldVote = r.Votes[i].Vote
+	}
+	if oldVote == newVote {
+		return
+	}
+
+	if i != -1 {
+		r.Votes = slices.Delete(r.Votes, i, i+1)
+	}
+	if newVote != NOVOTE {
+		r.Votes = append(r.Votes, Vote{userID, newVote})
+	}
+
+	r.Score += int(newVote - oldVote)
+	r.UpvotePercentage = countUpvotePercentage(r.Votes)
+}
+
+func (r Rating) clone() Rating {
+	votes := make([]Vote, len(r.Votes))
+	copy(votes, r.Votes)
+	r.Votes = votes
+	return r
+}
+
+func countUpvotePercentage(votes []Vote) int {
+	if len(votes) == 0 {
+		return 0
+	}
+
+	upvotes := 0
+	for _, vote := range votes {
+		if vote.Vote == UPVOTE {
+			upvotes += 1
+		}
+	}
+
+	return int(float64(upvotes) / float64(len(votes)) * 100)
+}
+
+func countVotes(votes []Vote) (ups, downs int) {
+	for _, v := range votes {
+		switch v.Vote {
+		case UPVOTE:
+			ups++
+		case DOWNVOTE:
+			downs++
+		}
+	}
+
+	return ups, downs
+}
+
+// controversy is high for many votes split evenly between up and down.
+func controversy(votes []Vote) float64 {
+	ups, downs := countVotes(votes)
+	if ups == 0 || downs == 0 {
+		return 0
+	}
+
+	magnitude := float64(ups + downs)
+	balance := float64(downs) / float64(ups)
+	if ups < downs {
+		balance = float64(ups) / float64(downs)
+	}
+
+	return math.Pow(magnitude, balance)
+}
+
+// confidence is the lower bound of the Wilson score interval of the share
+// of upvotes. It ranks items with few votes below equally good items with
+// many, which is Reddit's "best" order of comments.
+func confidence(votes []Vote) float64 {
+	ups, downs := countVotes(votes)
+	n := float64(ups + downs)
+	if n == 0 {
+		return 0
+	}
+
+	const z = 1.281551565545 // 80% confidence
+	p := float64(ups) / n
+	left := p + z*z/(2*n)
+	right := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	under := 1 + z*z/n
+
+	return (left - right) / under
+}
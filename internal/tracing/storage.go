@@ -0,0 +1,247 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"redditclone/internal/authz"
+	"redditclone/internal/storage"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Storage traces every call to the storage it wraps. The spans are
+// children of the span in the context given to WithContext.
+type Storage struct {
+	next   storage.Storage
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func NewStorage(next storage.Storage) *Storage {
+	return &Storage{
+		next:   next,
+		ctx:    context.Background(),
+		tracer: otel.Tracer("redditclone/internal/storage"),
+	}
+}
+
+// WithContext returns the storage tracing calls as part of the trace in ctx.
+func (s *Storage) WithContext(ctx context.Context) storage.Storage {
+	traced := *s
+	traced.ctx = ctx
+	return &traced
+}
+
+// Close closes the wrapped storage if it holds resources.
+func (s *Storage) Close() error {
+	if closer, ok := s.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *Storage) start(method string) trace.Span {
+	_, span := s.tracer.Start(s.ctx, "storage."+method, trace.WithSpanKind(trace.SpanKindInternal))
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func call[T any](s *Storage, method string, f func() (T, error)) (T, error) {
+	span := s.start(method)
+	v, err := f()
+	end(span, err)
+	return v, err
+}
+
+func value[T any](s *Storage, method string, f func() T) T {
+	span := s.start(method)
+	defer span.End()
+	return f()
+}
+
+func run(s *Storage, method string, f func() error) error {
+	span := s.start(method)
+	err := f()
+	end(span, err)
+	return err
+}
+
+func (s *Storage) GetUser(name, password string) (storage.User, error) {
+	return call(s, "GetUser", func() (storage.User, error) { return s.next.GetUser(name, password) })
+}
+
+func (s *Storage) AddUser(name, password string) (storage.User, error) {
+	return call(s, "AddUser", func() (storage.User, error) { return s.next.AddUser(name, password) })
+}
+
+func (s *Storage) FindUser(name string) (storage.User, error) {
+	return call(s, "FindUser", func() (storage.User, error) { return s.next.FindUser(name) })
+}
+
+func (s *Storage) SetUserRole(name string, role authz.Role) (storage.User, error) {
+	return call(s, "SetUserRole", func() (storage.User, error) { return s.next.SetUserRole(name, role) })
+}
+
+func (s *Storage) SetUserBan(name string, ban storage.Ban) (storage.User, error) {
+	return call(s, "SetUserBan", func() (storage.User, error) { return s.next.SetUserBan(name, ban) })
+}
+
+func (s *Storage) ShadowbannedUsers() []string {
+	return value(s, "ShadowbannedUsers", s.next.ShadowbannedUsers)
+}
+
+func (s *Storage) CountUsers() int {
+	return value(s, "CountUsers", s.next.CountUsers)
+}
+
+func (s *Storage) AddPost(rawPost storage.RawPost, authorName string, authorID string) (storage.Post, error) {
+	return call(s, "AddPost", func() (storage.Post, error) { return s.next.AddPost(rawPost, authorName, authorID) })
+}
+
+func (s *Storage) DeletePost(postID string, actor authz.Subject) error {
+	return run(s, "DeletePost", func() error { return s.next.DeletePost(postID, actor) })
+}
+
+func (s *Storage) GetPosts() []storage.Post {
+	return value(s, "GetPosts", s.next.GetPosts)
+}
+
+func (s *Storage) ListPosts(query storage.PostQuery) (storage.PostPage, error) {
+	return call(s, "ListPosts", func() (storage.PostPage, error) { return s.next.ListPosts(query) })
+}
+
+func (s *Storage) GetPost(id string) (storage.Post, error) {
+	return call(s, "GetPost", func() (storage.Post, error) { return s.next.GetPost(id) })
+}
+
+func (s *Storage) UpvotePost(postID, userID string) (storage.Post, error) {
+	return call(s, "UpvotePost", func() (storage.Post, error) { return s.next.UpvotePost(postID, userID) })
+}
+
+func (s *Storage) DownvotePost(postID, userID string) (storage.Post, error) {
+	return call(s, "DownvotePost", func() (storage.Post, error) { return s.next.DownvotePost(postID, userID) })
+}
+
+func (s *Storage) UnvotePost(postID, userID string) (storage.Post, error) {
+	return call(s, "UnvotePost", func() (storage.Post, error) { return s.next.UnvotePost(postID, userID) })
+}
+
+func (s *Storage) AddComment(postID, userID, username, message string) (storage.Post, error) {
+	return call(s, "AddComment", func() (storage.Post, error) { return s.next.AddComment(postID, userID, username, message) })
+}
+
+func (s *Storage) AddReply(postID, parentID, userID, username, message string) (storage.Post, error) {
+	return call(s, "AddReply", func() (storage.Post, error) { return s.next.AddReply(postID, parentID, userID, username, message) })
+}
+
+func (s *Storage) UpvoteComment(postID, commentID, userID string) (storage.Post, error) {
+	return call(s, "UpvoteComment", func() (storage.Post, error) { return s.next.UpvoteComment(postID, commentID, userID) })
+}
+
+func (s *Storage) DownvoteComment(postID, commentID, userID string) (storage.Post, error) {
+	return call(s, "DownvoteComment", func() (storage.Post, error) { return s.next.DownvoteComment(postID, commentID, userID) })
+}
+
+func (s *Storage) UnvoteComment(postID, commentID, userID string) (storage.Post, error) {
+	return call(s, "UnvoteComment", func() (storage.Post, error) { return s.next.UnvoteComment(postID, commentID, userID) })
+}
+
+func (s *Storage) DeleteComment(postID string, actor authz.Subject, commentID string) (storage.Post, error) {
+	return call(s, "DeleteComment", func() (storage.Post, error) { return s.next.DeleteComment(postID, actor, commentID) })
+}
+
+func (s *Storage) EditPost(postID string, actor authz.Subject, edit storage.PostEdit) (storage.Post, error) {
+	return call(s, "EditPost", func() (storage.Post, error) { return s.next.EditPost(postID, actor, edit) })
+}
+
+func (s *Storage) EditComment(postID, commentID string, actor authz.Subject, body string) (storage.Post, error) {
+	return call(s, "EditComment", func() (storage.Post, error) { return s.next.EditComment(postID, commentID, actor, body) })
+}
+
+func (s *Storage) AddViews(views map[string]int) error {
+	return run(s, "AddViews", func() error { return s.next.AddViews(views) })
+}
+
+func (s *Storage) ReportPost(postID string, report storage.Report) (storage.Post, error) {
+	return call(s, "ReportPost", func() (storage.Post, error) { return s.next.ReportPost(postID, report) })
+}
+
+func (s *Storage) ReportComment(postID, commentID string, report storage.Report) (storage.Post, error) {
+	return call(s, "ReportComment", func() (storage.Post, error) { return s.next.ReportComment(postID, commentID, report) })
+}
+
+func (s *Storage) ModeratePost(postID string, decision storage.ModStatus, moderator storage.PostAuthor) (storage.Post, error) {
+	return call(s, "ModeratePost", func() (storage.Post, error) { return s.next.ModeratePost(postID, decision, moderator) })
+}
+
+func (s *Storage) ModerateComment(postID, commentID string, decision storage.ModStatus, moderator storage.PostAuthor) (storage.Post, error) {
+	return call(s, "ModerateComment", func() (storage.Post, error) { return s.next.ModerateComment(postID, commentID, decision, moderator) })
+}
+
+func (s *Storage) GetModQueue(query storage.ModQueueQuery) []storage.QueueItem {
+	return value(s, "GetModQueue", func() []storage.QueueItem { return s.next.GetModQueue(query) })
+}
+
+func (s *Storage) AddCommunity(community storage.Community) (storage.Community, error) {
+	return call(s, "AddCommunity", func() (storage.Community, error) { return s.next.AddCommunity(community) })
+}
+
+func (s *Storage) GetCommunity(name string) (storage.Community, error) {
+	return call(s, "GetCommunity", func() (storage.Community, error) { return s.next.GetCommunity(name) })
+}
+
+func (s *Storage) GetCommunities() []storage.Community {
+	return value(s, "GetCommunities", s.next.GetCommunities)
+}
+
+func (s *Storage) AddModerator(name string, moderator storage.PostAuthor) (storage.Community, error) {
+	return call(s, "AddModerator", func() (storage.Community, error) { return s.next.AddModerator(name, moderator) })
+}
+
+func (s *Storage) RemoveModerator(name, userID string) (storage.Community, error) {
+	return call(s, "RemoveModerator", func() (storage.Community, error) { return s.next.RemoveModerator(name, userID) })
+}
+
+func (s *Storage) ModeratedCommunities(userID string) []string {
+	return value(s, "ModeratedCommunities", func() []string { return s.next.ModeratedCommunities(userID) })
+}
+
+func (s *Storage) AddSession(userID, userName, refreshHash string, expiresAt time.Time) (storage.Session, error) {
+	return call(s, "AddSession", func() (storage.Session, error) { return s.next.AddSession(userID, userName, refreshHash, expiresAt) })
+}
+
+func (s *Storage) GetSession(id string) (storage.Session, error) {
+	return call(s, "GetSession", func() (storage.Session, error) { return s.next.GetSession(id) })
+}
+
+func (s *Storage) RefreshSession(refreshHash, nextHash string, expiresAt time.Time) (storage.Session, error) {
+	return call(s, "RefreshSession", func() (storage.Session, error) { return s.next.RefreshSession(refreshHash, nextHash, expiresAt) })
+}
+
+func (s *Storage) DeleteSession(id string) error {
+	return run(s, "DeleteSession", func() error { return s.next.DeleteSession(id) })
+}
+
+func (s *Storage) DeleteUserSessions(userID string) error {
+	return run(s, "DeleteUserSessions", func() error { return s.next.DeleteUserSessions(userID) })
+}
+
+func (s *Storage) AddAuditEntry(entry storage.AuditEntry) (storage.AuditEntry, error) {
+	return call(s, "AddAuditEntry", func() (storage.AuditEntry, error) { return s.next.AddAuditEntry(entry) })
+}
+
+func (s *Storage) GetAuditLog() []storage.AuditEntry {
+	return value(s, "GetAuditLog", s.next.GetAuditLog)
+}
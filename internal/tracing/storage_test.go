@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"redditclone/internal/storage"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStorage(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	s := NewStorage(storage.NewInMemStorage())
+	s.tracer = provider.Tracer("test")
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	traced := s.WithContext(ctx)
+	traced.AddUser("alice", "secret")
+	_, err := traced.GetUser("alice", "wrong")
+	parent.End()
+	if err == nil {
+		t.Fatal("GetUser with a wrong password succeeded")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans, want AddUser, GetUser and the request", len(spans))
+	}
+	for i, name := range []string{"storage.AddUser", "storage.GetUser"} {
+		span := spans[i]
+		if span.Name() != name {
+			t.Errorf("span %d is %s, want %s", i, span.Name(), name)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the request span", span.Name())
+		}
+	}
+
+	failed := spans[1]
+	if failed.Status().Code != codes.Error {
+		t.Errorf("GetUser status %v, want error", failed.Status().Code)
+	}
+	if events := failed.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("GetUser events %v, want the recorded error", events)
+	}
+	if spans[0].Status().Code == codes.Error {
+		t.Error("AddUser failed")
+	}
+}
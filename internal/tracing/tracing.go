@@ -0,0 +1,72 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported by a
+// configurable exporter, trace context is propagated in W3C traceparent
+// headers, and Storage traces storage calls without changing the backends.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+)
+
+// ServiceName identifies the spans of the service.
+const ServiceName = "redditclone"
+
+// Install makes a tracer provider exporting to exporter and the W3C trace
+// context propagator the global ones. sampleRatio is the share of new
+// traces that are recorded, traces of callers follow their decision.
+// Shut the provider down to flush buffered spans.
+func Install(exporter sdktrace.SpanExporter, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider, nil
+}
+
+// fileExporter writes spans to a file as JSON, one span per line.
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// NewFileExporter appends spans to the file at path, for reading them
+// without a collector.
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("trace file: %w", err)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileExporter{SpanExporter: exporter, file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package validate
+
+import (
+	"fmt"
+	"net/url"
+	"redditclone/internal/storage"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Lengths of posts and comments are counted in characters.
+const (
+	MaxTitleLength   = 300
+	MaxTextLength    = 40000
+	MaxURLLength     = 2048
+	MaxCommentLength = 10000
+)
+
+// linkSchemes are the schemes link posts may point to, anything else such
+// as javascript: or data: could run in the browser of the reader.
+var linkSchemes = []string{"http", "https"}
+
+// Post checks a new post. Content is the text of text posts and the URL of
+// link posts.
+func Post(postType storage.PostType, title, content string) []Error {
+	var errs []Error
+
+	var contentErr *Error
+	switch postType {
+	case storage.TEXT:
+		contentErr = Text(content)
+	case storage.LINK:
+		contentErr = URL(content)
+	default:
+		errs = append(errs, Error{Param: "type", Value: string(postType), Message: "must be text or link"})
+	}
+
+	if err := Title(title); err != nil {
+		errs = append(errs, *err)
+	}
+	if contentErr != nil {
+		errs = append(errs, *contentErr)
+	}
+
+	return errs
+}
+
+func Title(title string) *Error {
+	return length("title", title, MaxTitleLength)
+}
+
+// Text checks the text of a text post.
+func Text(text string) *Error {
+	return length("text", text, MaxTextLength)
+}
+
+// URL checks the URL of a link post: it has to be an absolute http or
+// https URL.
+func URL(raw string) *Error {
+	invalid := func(message string) *Error {
+		return &Error{Param: "url", Value: raw, Message: message}
+	}
+
+	if err := length("url", raw, MaxURLLength); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return invalid("must be a valid URL")
+	}
+	if !slices.Contains(linkSchemes, strings.ToLower(u.Scheme)) {
+		return invalid("must be an http or https URL")
+	}
+	if u.Hostname() == "" {
+		return invalid("must have a host")
+	}
+
+	return nil
+}
+
+func Comment(body string) *Error {
+	return length("comment", body, MaxCommentLength)
+}
+
+// length checks that a required field is not blank and at most max
+// characters long. Long values are not echoed back.
+func length(param, value string, max int) *Error {
+	if strings.TrimSpace(value) == "" {
+		return &Error{Param: param, Value: value, Message: "required"}
+	}
+	if utf8.RuneCountInString(value) > max {
+		return &Error{Param: param, Message: fmt.Sprintf("must be at most %d characters", max)}
+	}
+	return nil
+}
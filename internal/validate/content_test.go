@@ -0,0 +1,53 @@
+package validate
+
+import (
+	"redditclone/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		postType storage.PostType
+		title    string
+		content  string
+		params   []string
+	}{
+		{storage.TEXT, "hello", "world", nil},
+		{storage.LINK, "hello", "https://example.com/a?b=c", nil},
+		{storage.LINK, "hello", "HTTP://example.com", nil},
+		{"image", "hello", "world", []string{"type"}},
+		{storage.TEXT, "  ", "world", []string{"title"}},
+		{storage.TEXT, strings.Repeat("ж", MaxTitleLength), "world", nil},
+		{storage.TEXT, strings.Repeat("ж", MaxTitleLength+1), "world", []string{"title"}},
+		{storage.TEXT, "hello", "", []string{"text"}},
+		{storage.TEXT, "hello", strings.Repeat("a", MaxTextLength+1), []string{"text"}},
+		{storage.LINK, "hello", "javascript:alert(1)", []string{"url"}},
+		{storage.LINK, "hello", "data:text/html,hi", []string{"url"}},
+		{storage.LINK, "hello", "example.com", []string{"url"}},
+		{storage.LINK, "hello", "https://", []string{"url"}},
+		{storage.LINK, "", "ftp://example.com", []string{"title", "url"}},
+	}
+
+	for _, tt := range tests {
+		var params []string
+		for _, err := range Post(tt.postType, tt.title, tt.content) {
+			params = append(params, err.Param)
+		}
+		if strings.Join(params, ",") != strings.Join(tt.params, ",") {
+			t.Errorf("Post(%q, %.20q, %.20q) rejected %v, want %v", tt.postType, tt.title, tt.content, params, tt.params)
+		}
+	}
+}
+
+func TestComment(t *testing.T) {
+	if err := Comment("nice post"); err != nil {
+		t.Errorf("Comment rejected a valid comment: %v", err)
+	}
+	if err := Comment(" \n"); err == nil || err.Param != "comment" {
+		t.Errorf("Comment(blank) = %v, want a comment error", err)
+	}
+	if err := Comment(strings.Repeat("a", MaxCommentLength+1)); err == nil || err.Value != "" {
+		t.Errorf("Comment(too long) = %+v, want an error without the value", err)
+	}
+}
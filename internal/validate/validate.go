@@ -0,0 +1,117 @@
+// Package validate checks user input against the rules of the site.
+package validate
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Error is a rejected field of a request.
+type Error struct {
+	Param string
+	// Value is the rejected value, secrets are left out.
+	Value   string
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Param, e.Message)
+}
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MinPasswordLength = 8
+	// MaxPasswordLength is the number of bytes bcrypt hashes.
+	MaxPasswordLength = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// reservedNames can not be registered in any case, they would be mistaken
+// for the site itself or for placeholders of missing users.
+var reservedNames = []string{
+	"admin", "administrator", "moderator", "mod", "mods", "system",
+	"support", "staff", "official", "api", "www", "static", "deleted",
+	"removed", "anonymous", "null", "undefined", "me", "everyone",
+}
+
+//go:embed commonPasswords.txt
+var commonPasswordsFile string
+
+var commonPasswords = sync.OnceValue(func() map[string]bool {
+	passwords := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+})
+
+// Registration checks the username and the password of a new user.
+// Uniqueness of the username is left to the storage.
+func Registration(username, password string) []Error {
+	var errs []Error
+	if err := Username(username); err != nil {
+		errs = append(errs, *err)
+	}
+	if err := Password(password, username); err != nil {
+		errs = append(errs, *err)
+	}
+	return errs
+}
+
+func Username(name string) *Error {
+	invalid := func(message string) *Error {
+		return &Error{Param: "username", Value: name, Message: message}
+	}
+
+	switch {
+	case name == "":
+		return invalid("required")
+	case len(name) < MinUsernameLength:
+		return invalid(fmt.Sprintf("must be at least %d characters", MinUsernameLength))
+	case len(name) > MaxUsernameLength:
+		return invalid(fmt.Sprintf("must be at most %d characters", MaxUsernameLength))
+	case !usernamePattern.MatchString(name):
+		return invalid("may only contain letters, digits, _ and -")
+	}
+
+	for _, reserved := range reservedNames {
+		if strings.EqualFold(name, reserved) {
+			return invalid("is reserved")
+		}
+	}
+
+	return nil
+}
+
+// Password checks the password of the user. The password itself is never
+// put into the Error.
+func Password(password, username string) *Error {
+	invalid := func(message string) *Error {
+		return &Error{Param: "password", Message: message}
+	}
+
+	switch {
+	case password == "":
+		return invalid("required")
+	case len(password) < MinPasswordLength:
+		return invalid(fmt.Sprintf("must be at least %d characters", MinPasswordLength))
+	case len(password) > MaxPasswordLength:
+		return invalid(fmt.Sprintf("must be at most %d bytes", MaxPasswordLength))
+	case username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)):
+		return invalid("must not contain the username")
+	case commonPasswords()[strings.ToLower(password)]:
+		return invalid("is too common")
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistration(t *testing.T) {
+	tests := []struct {
+		username, password string
+		// params are the rejected fields
+		params []string
+	}{
+		{"alice", "tumbling-otter-42", nil},
+		{"Bob_the-2nd", "tumbling-otter-42", nil},
+		{"", "", []string{"username", "password"}},
+		{"al", "tumbling-otter-42", []string{"username"}},
+		{strings.Repeat("a", 33), "tumbling-otter-42", []string{"username"}},
+		{"alice smith", "tumbling-otter-42", []string{"username"}},
+		{"ÄLICE", "tumbling-otter-42", []string{"username"}},
+		{"Admin", "tumbling-otter-42", []string{"username"}},
+		{"alice", "short", []string{"password"}},
+		{"alice", strings.Repeat("a", 73), []string{"password"}},
+		{"alice", "Password123", []string{"password"}},
+		{"alice", "xx-ALICE-xx", []string{"password"}},
+	}
+
+	for _, tt := range tests {
+		errs := Registration(tt.username, tt.password)
+
+		var params []string
+		for _, err := range errs {
+			params = append(params, err.Param)
+			if err.Param == "password" && err.Value != "" {
+				t.Errorf("Registration(%q, %q) put the password into the error", tt.username, tt.password)
+			}
+		}
+		if strings.Join(params, ",") != strings.Join(tt.params, ",") {
+			t.Errorf("Registration(%q, %q) rejected %v, want %v", tt.username, tt.password, errs, tt.params)
+		}
+	}
+}
+
+func TestCommonPasswords(t *testing.T) {
+	passwords := commonPasswords()
+	if len(passwords) < 100 {
+		t.Fatalf("loaded %d common passwords", len(passwords))
+	}
+	for password := range passwords {
+		if strings.HasPrefix(password, "#") || len(password) < MinPasswordLength {
+			t.Errorf("blocklist entry %q is a comment or too short to matter", password)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package views counts post views. Repeated views by the same viewer are
+// counted once per window, and counts are buffered in memory and written
+// to storage in batches, so reading a post never waits for storage writes.
+package views
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWindow        = time.Hour
+	DefaultFlushInterval = 10 * time.Second
+)
+
+// Storage persists batches of view counts, keyed by post ID.
+type Storage interface {
+	AddViews(views map[string]int) error
+}
+
+type viewKey struct {
+	postID string
+	viewer string
+}
+
+type Counter struct {
+	storage Storage
+	window  time.Duration
+
+	mu sync.Mutex
+	// seen holds the last counted view of every viewer within the window
+	seen    map[viewKey]time.Time
+	pending map[string]int
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCounter starts a counter that flushes pending views to storage every
+// flushInterval until it is closed.
+func NewCounter(storage Storage, window, flushInterval time.Duration) *Counter {
+	c := &Counter{
+		storage: storage,
+		window:  window,
+		seen:    map[viewKey]time.Time{},
+		pending: map[string]int{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go c.run(flushInterval)
+	return c
+}
+
+func (c *Counter) run(flushInterval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := c.Flush()
+			if err != nil {
+				slog.Error("flush views", "err", err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// View records a view of the post and reports whether it was counted.
+func (c *Counter) View(postID, viewer string) bool {
+	key := viewKey{postID, viewer}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last, ok := c.seen[key]
+	if ok && now.Sub(last) < c.window {
+		return false
+	}
+
+	c.seen[key] = now
+	c.pending[postID]++
+	return true
+}
+
+// Pending returns the views of the post that are not flushed yet.
+func (c *Counter) Pending(postID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pending[postID]
+}
+
+// Flush writes pending views to storage and forgets viewers whose window
+// has passed. Views are kept pending if storage fails.
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[string]int{}
+
+	now := time.Now()
+	for key, last := range c.seen {
+		if now.Sub(last) >= c.window {
+			delete(c.seen, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := c.storage.AddViews(pending)
+	if err != nil {
+		c.mu.Lock()
+		for postID, n := range pending {
+			c.pending[postID] += n
+		}
+		c.mu.Unlock()
+	}
+
+	return err
+}
+
+// Close stops periodic flushing and flushes the remaining views.
+func (c *Counter) Close() error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+
+	return c.Flush()
+}
@@ -0,0 +1,145 @@
+package views
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage records flushed views and fails while err is set.
+type fakeStorage struct {
+	mu    sync.Mutex
+	views map[string]int
+	err   error
+}
+
+func (s *fakeStorage) AddViews(views map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	for postID, n := range views {
+		s.views[postID] += n
+	}
+	return nil
+}
+
+func (s *fakeStorage) stored() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.views)
+}
+
+func newTestCounter(t *testing.T, window time.Duration) (*Counter, *fakeStorage) {
+	s := &fakeStorage{views: map[string]int{}}
+	c := NewCounter(s, window, time.Hour)
+	t.Cleanup(func() { c.Close() })
+	return c, s
+}
+
+func TestViewWindow(t *testing.T) {
+	const window = 50 * time.Millisecond
+	c, _ := newTestCounter(t, window)
+
+	tests := []struct {
+		name   string
+		postID string
+		viewer string
+		sleep  time.Duration
+		want   bool
+	}{
+		{"first view", "p1", "alice", 0, true},
+		{"repeat within the window", "p1", "alice", 0, false},
+		{"other viewer", "p1", "bob", 0, true},
+		{"other post", "p2", "alice", 0, true},
+		{"repeat after the window", "p1", "alice", window, true},
+	}
+	for _, tt := range tests {
+		time.Sleep(tt.sleep)
+		if got := c.View(tt.postID, tt.viewer); got != tt.want {
+			t.Errorf("%s: View(%s, %s) = %v, want %v", tt.name, tt.postID, tt.viewer, got, tt.want)
+		}
+	}
+
+	if got := c.Pending("p1"); got != 3 {
+		t.Errorf("Pending(p1) = %d, want 3", got)
+	}
+	if got := c.Pending("p2"); got != 1 {
+		t.Errorf("Pending(p2) = %d, want 1", got)
+	}
+	if got := c.Pending("p3"); got != 0 {
+		t.Errorf("Pending(p3) = %d, want 0", got)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c, s := newTestCounter(t, time.Hour)
+	c.View("p1", "alice")
+	c.View("p1", "bob")
+
+	err := c.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := s.stored()["p1"]; got != 2 {
+		t.Errorf("stored views of p1 = %d, want 2", got)
+	}
+	if got := c.Pending("p1"); got != 0 {
+		t.Errorf("Pending(p1) after Flush = %d, want 0", got)
+	}
+
+	// flushed viewers are still within their window
+	if c.View("p1", "alice") {
+		t.Error("repeat view after Flush was counted")
+	}
+}
+
+func TestFlushFailure(t *testing.T) {
+	c, s := newTestCounter(t, time.Hour)
+	c.View("p1", "alice")
+
+	s.err = errors.New("storage down")
+	err := c.Flush()
+	if !errors.Is(err, s.err) {
+		t.Fatalf("Flush: got %v, want %v", err, s.err)
+	}
+	if got := c.Pending("p1"); got != 1 {
+		t.Errorf("Pending(p1) after a failed Flush = %d, want 1", got)
+	}
+
+	// views counted meanwhile join the ones put back
+	c.View("p1", "bob")
+	s.err = nil
+	err = c.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := s.stored()["p1"]; got != 2 {
+		t.Errorf("stored views of p1 = %d, want 2", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, s := newTestCounter(t, time.Hour)
+	c.View("p1", "alice")
+	c.View("p2", "alice")
+
+	err := c.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got, want := s.stored(), map[string]int{"p1": 1, "p2": 1}; !maps.Equal(got, want) {
+		t.Errorf("stored views after Close = %v, want %v", got, want)
+	}
+
+	// closing again flushes nothing new
+	err = c.Close()
+	if err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}